@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 	"unsafe"
 
 	"google.golang.org/protobuf/proto"
@@ -17,7 +20,7 @@ import (
 )
 
 func isIgnored(path string) bool {
-	for _, part := range filepath.SplitList(path) {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
 		if part == ".ugit" || part == ".git" {
 			return true
 		}
@@ -25,19 +28,32 @@ func isIgnored(path string) bool {
 	return false
 }
 
-func emptyCwd() error {
-	directory := "."
-	entries, err := os.ReadDir(directory)
+// checkPath fails unless path, slash separated, names a place in the
+// worktree that may be written to: it must be relative, have no empty, "."
+// or ".." components and stay out of any git dir.
+func checkPath(path string) error {
+	if path == "" || strings.HasPrefix(path, "/") {
+		return errors.New(fmt.Sprintf("invalid path '%s'", path))
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." || part == ".." || isIgnored(part) {
+			return errors.New(fmt.Sprintf("invalid path '%s'", path))
+		}
+	}
+	return nil
+}
+
+func (repo *Repository) emptyWorkTree() error {
+	entries, err := os.ReadDir(repo.workPath(""))
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range entries {
-		full := filepath.Join(directory, entry.Name())
-		if isIgnored(full) {
+		if isIgnored(entry.Name()) {
 			continue
 		}
-		err := os.RemoveAll(full)
+		err := os.RemoveAll(repo.workPath(entry.Name()))
 		if err != nil {
 			return err
 		}
@@ -56,9 +72,9 @@ func uint64ToByteArray(num uint64) ([]byte, error) {
 
 var sizeOfUint64 uint64 = uint64(unsafe.Sizeof(uint64(1)))
 
-func readUint64(fh *os.File) (uint64, error) {
+func readUint64(fh io.Reader) (uint64, error) {
 	uint64Buf := make([]byte, sizeOfUint64)
-	_, err := fh.Read(uint64Buf)
+	_, err := io.ReadFull(fh, uint64Buf)
 	if err != nil {
 		return 0, err
 	}
@@ -66,13 +82,15 @@ func readUint64(fh *os.File) (uint64, error) {
 	return num, err
 }
 
+// byteArrayToUint64 is the inverse of uint64ToByteArray. It used to decode a
+// uvarint, which only agreed with the fixed-width encoding below 128.
 func byteArrayToUint64(b []byte) (num uint64, size uint64, err error) {
-	buf := bytes.NewBuffer(b)
-	num, err = binary.ReadUvarint(buf)
-	buf = new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, uint64(5))
-	// fmt.Printf("byteArrayToUint64 %d\n[% x]\n[% x]\n", num, b[:sizeOfUint64], buf.Bytes())
-	return num, sizeOfUint64, err
+	if uint64(len(b)) < sizeOfUint64 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	num = binary.LittleEndian.Uint64(b)
+	// fmt.Printf("byteArrayToUint64 %d\n[% x]\n", num, b[:sizeOfUint64])
+	return num, sizeOfUint64, nil
 
 }
 func ugitObjectMarshal(obj *UgitObject) ([]byte, error) {
@@ -88,18 +106,26 @@ func ugitObjectMarshal(obj *UgitObject) ([]byte, error) {
 	return sizeBuf, nil
 }
 
-func iterTreeEntries(oid string) ([]UgitObject, error) {
+func (repo *Repository) iterTreeEntries(oid string) ([]UgitObject, error) {
 	objectList := make([]UgitObject, 0)
 	if oid == "" {
 		return nil, errors.New("iterTreeEntities requires an oid")
 	}
-	fh, err := data.GetObject(oid, "tree")
+	fh, err := repo.getObject(oid, "tree")
 	if err != nil {
 		return nil, err
 	}
 	defer fh.Close()
+	if git, err := repo.isGitFormat(); err != nil {
+		return nil, err
+	} else if git {
+		return decodeGitTree(fh)
+	}
 	// read item count
 	count, err := readUint64(fh)
+	if err != nil {
+		return nil, err
+	}
 	for i := uint64(0); i < count; i++ {
 		len, err := readUint64(fh)
 		if err != nil {
@@ -107,7 +133,7 @@ func iterTreeEntries(oid string) ([]UgitObject, error) {
 		}
 		ugitObjectBuf := make([]byte, len)
 		object := UgitObject{}
-		fh.Read(ugitObjectBuf)
+		_, err = io.ReadFull(fh, ugitObjectBuf)
 		if err != nil {
 			return nil, err
 		}
@@ -120,19 +146,33 @@ func iterTreeEntries(oid string) ([]UgitObject, error) {
 			Type_: object.GetType_(),
 			Oid:   object.GetOid(),
 			Name:  object.GetName(),
+			Mode:  object.GetMode(),
 		})
 	}
-	return objectList, err
+	return objectList, expectEOF(fh, oid)
+}
+
+// expectEOF makes sure nothing follows what was read of an object, since a
+// damaged length would otherwise go unnoticed.
+func expectEOF(fh io.Reader, oid string) error {
+	_, err := io.ReadFull(fh, make([]byte, 1))
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return errors.New(fmt.Sprintf("unexpected data at the end of %s", oid))
 }
 
 type tupleOidPath struct {
 	oid  string
 	path string
+	mode uint32
 }
 
-func GetTree(oid string, basePath string) ([]tupleOidPath, error) {
-	tree, err := iterTreeEntries(oid)
-	fmt.Printf("iterTreeEntries ret %d\n", len(tree))
+func (repo *Repository) GetTree(oid string, basePath string) ([]tupleOidPath, error) {
+	tree, err := repo.iterTreeEntries(oid)
+	// fmt.Printf("iterTreeEntries ret %d\n", len(tree))
 	list := make([]tupleOidPath, 0)
 	if err != nil {
 		return nil, err
@@ -142,20 +182,23 @@ func GetTree(oid string, basePath string) ([]tupleOidPath, error) {
 		basePath = "./"
 	}
 	for i := 0; i < len(tree); i++ {
-		fmt.Printf("%s %s %s\n", tree[i].Type_, tree[i].Oid, tree[i].Name)
+		// fmt.Printf("%s %s %s\n", tree[i].Type_, tree[i].Oid, tree[i].Name)
 		full := filepath.Join(basePath, tree[i].Name)
 		if strings.Index(tree[i].Name, "/") >= 0 {
 			return nil, errors.New(fmt.Sprintf("GetTree error: unexpected '/' in %s [oid: %s]", tree[i].Name, tree[i].Oid))
 		} else if tree[i].Name == "." || tree[i].Name == ".." {
 			return nil, errors.New(fmt.Sprintf("GetTree error: unexpected object \"%s\" [oid: %s]", tree[i].Name, tree[i].Oid))
+		} else if tree[i].Name == "" || isIgnored(tree[i].Name) {
+			return nil, errors.New(fmt.Sprintf("GetTree error: refusing entry \"%s\" [oid: %s]", tree[i].Name, tree[i].Oid))
 		} else if tree[i].Type_ == "blob" {
 			tuple := tupleOidPath{
 				path: full,
 				oid:  tree[i].Oid,
+				mode: entryMode(&tree[i]),
 			}
 			list = append(list, tuple)
 		} else if tree[i].Type_ == "tree" {
-			localList, err := GetTree(tree[i].Oid, full)
+			localList, err := repo.GetTree(tree[i].Oid, full)
 			if err != nil {
 				return nil, err
 			}
@@ -167,92 +210,212 @@ func GetTree(oid string, basePath string) ([]tupleOidPath, error) {
 	// fmt.Printf("GetTree %d\n", len(list))
 	return list, nil
 }
-func ReadTree(tree_oid string) error {
-	list, err := GetTree(tree_oid, "./")
+func (repo *Repository) ReadTree(tree_oid string) error {
+	if err := repo.CheckWorkTree(); err != nil {
+		return err
+	}
+	list, err := repo.GetTree(tree_oid, "")
 	if err != nil {
 		return err
 	}
 	// fmt.Printf("ReadTree %d\n", len(list))
 
-	emptyCwd()
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
 
+	err = repo.emptyWorkTree()
+	if err != nil {
+		return err
+	}
+
+	index := map[string]*IndexEntry{}
 	for _, tuple := range list {
-		fmt.Printf("%s %s\n", tuple.oid, tuple.path)
-		basedir, _ := filepath.Split(tuple.path)
-		os.MkdirAll(basedir, os.FileMode(0755))
-		fo, err := data.GetObject(tuple.oid, "")
+		// fmt.Printf("%s %s\n", tuple.oid, tuple.path)
+		path := filepath.ToSlash(tuple.path)
+		err = repo.checkoutBlob(tuple.oid, path, tuple.mode)
 		if err != nil {
 			return err
 		}
-		defer fo.Close()
-		fi, err := os.Create(tuple.path)
+		index[path], err = repo.checkedOutEntry(path, tuple.oid, tuple.mode)
 		if err != nil {
 			return err
 		}
-		defer fi.Close()
-		buf := make([]byte, 1024)
-		for {
-			bytesRead, err := fo.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			} else if bytesRead == 0 {
-				break
-			}
-			fi.Write(buf[:bytesRead])
+	}
+	return repo.commitIndex(lock, index)
+}
+
+// getTreeMap flattens a tree into a map of path -> blob oid.
+// An empty oid yields an empty map.
+func (repo *Repository) getTreeMap(oid string) (map[string]string, error) {
+	treeMap := map[string]string{}
+	if oid == "" {
+		return treeMap, nil
+	}
+	list, err := repo.GetTree(oid, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, tuple := range list {
+		treeMap[filepath.ToSlash(tuple.path)] = tuple.oid
+	}
+	return treeMap, nil
+}
+
+// getTreeModes flattens a tree into a map of path -> mode, to go with
+// getTreeMap.
+func (repo *Repository) getTreeModes(oid string) (map[string]uint32, error) {
+	modes := map[string]uint32{}
+	if oid == "" {
+		return modes, nil
+	}
+	list, err := repo.GetTree(oid, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, tuple := range list {
+		modes[filepath.ToSlash(tuple.path)] = tuple.mode
+	}
+	return modes, nil
+}
+
+// checkoutBlob writes blob oid to path in the worktree: as a symlink to its
+// content when mode says so, and otherwise as a file, executable or not.
+// Where symlinks cannot be made, the file is written instead.
+func (repo *Repository) checkoutBlob(oid string, path string, mode uint32) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	path = repo.workPath(path)
+	basedir, _ := filepath.Split(path)
+	if basedir != "" {
+		if err := os.MkdirAll(basedir, os.FileMode(0755)); err != nil {
+			return err
 		}
 	}
-	return nil
+	// never write through a symlink, and start from fresh permissions
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	fo, err := repo.getObject(oid, "blob")
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	if mode == MODE_SYMLINK {
+		target, err := io.ReadAll(fo)
+		if err != nil {
+			return err
+		}
+		if os.Symlink(filepath.FromSlash(string(target)), path) == nil {
+			return nil
+		}
+		fo = io.NopCloser(bytes.NewReader(target))
+	}
+	perm := os.FileMode(0666)
+	if mode == MODE_EXECUTABLE {
+		perm = 0777
+	}
+	fi, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	_, err = io.Copy(fi, fo)
+	return err
 }
-func WriteTree(directory string) (oid string, err error) {
-	if directory == "" {
-		directory = "."
+
+// WriteTree stores directory, relative to the worktree ("" for all of it),
+// as a tree and returns its oid.
+func (repo *Repository) WriteTree(directory string) (oid string, err error) {
+	if err := repo.CheckWorkTree(); err != nil {
+		return "", err
 	}
-	entries, err := os.ReadDir(directory)
+	entries, err := os.ReadDir(repo.workPath(directory))
 
 	if err != nil {
 		return "", err
 	}
 
-	reader, writer := io.Pipe()
-	go func() {
-		defer writer.Close()
-		list := []*UgitObject{}
-		for _, entry := range entries {
-			full := filepath.Join(directory, entry.Name())
-			var type_ string
-			var oid string
-			if isIgnored(full) {
-				continue
-			} else if entry.IsDir() {
-				oid, err = WriteTree(full)
-				if err != nil {
-					panic(err)
-				}
-				type_ = "tree"
-
-			} else {
-				type_ = "blob"
-				fh, err := os.Open(full)
-				if err != nil {
-					panic(err)
-				}
-				defer fh.Close()
-				oid, err = data.HashObject(fh, "blob")
+	list := []*UgitObject{}
+	for _, entry := range entries {
+		full := path.Join(directory, entry.Name())
+		var type_ string
+		var oid string
+		mode := MODE_FILE
+		if isIgnored(full) {
+			continue
+		} else if entry.IsDir() {
+			oid, err = repo.WriteTree(full)
+			if err != nil {
+				return "", err
 			}
+			type_ = "tree"
+			mode = MODE_TREE
 
-			var tuple = &UgitObject{
-				Name:  entry.Name(),
-				Oid:   oid,
-				Type_: type_,
+		} else if entry.Type()&fs.ModeSymlink != 0 {
+			type_ = "blob"
+			mode = MODE_SYMLINK
+			target, err := os.Readlink(repo.workPath(full))
+			if err != nil {
+				return "", err
 			}
-			// fmt.Printf("%s %s %s\n", tuple.Oid, tuple.Type_, tuple.Name)
-			list = append(list, tuple)
+			oid, err = repo.Objects.Put(strings.NewReader(filepath.ToSlash(target)), "blob")
+			if err != nil {
+				return "", err
+			}
+		} else {
+			type_ = "blob"
+			fh, err := os.Open(repo.workPath(full))
+			if err != nil {
+				return "", err
+			}
+			oid, err = repo.Objects.Put(fh, "blob")
+			fh.Close()
+			if err != nil {
+				return "", err
+			}
+			if info, err := entry.Info(); err == nil && info.Mode()&0111 != 0 {
+				mode = MODE_EXECUTABLE
+			}
+		}
+
+		var tuple = &UgitObject{
+			Name:  entry.Name(),
+			Oid:   oid,
+			Type_: type_,
+			Mode:  mode,
+		}
+		// fmt.Printf("%s %s %s\n", tuple.Oid, tuple.Type_, tuple.Name)
+		list = append(list, tuple)
+	}
+	return repo.writeTreeObject(list)
+}
+
+// writeTreeObject stores list as a tree object. Entries are expected to be
+// sorted by name.
+func (repo *Repository) writeTreeObject(list []*UgitObject) (oid string, err error) {
+	if git, err := repo.isGitFormat(); err != nil {
+		return "", err
+	} else if git {
+		buf, err := encodeGitTree(list)
+		if err != nil {
+			return "", err
 		}
+		return repo.Objects.Put(bytes.NewReader(buf), "tree")
+	}
+	reader, writer := io.Pipe()
+	go func() {
 		// write data
 		// 1. write number of types
 		buf, err := uint64ToByteArray(uint64(len(list)))
 		if err != nil {
-			panic(err)
+			writer.CloseWithError(err)
+			return
 		}
 		writer.Write(buf)
 		// then write every item
@@ -260,72 +423,164 @@ func WriteTree(directory string) (oid string, err error) {
 			// fmt.Printf("%s %s %s\n", list[i].Oid, list[i].Type_, list[i].Name)
 			buf, err = ugitObjectMarshal(list[i])
 			if err != nil {
-				panic(err)
+				writer.CloseWithError(err)
+				return
 			}
 			writer.Write(buf)
 		}
+		writer.Close()
 	}()
 
 	// creat the tree object
-	oid, err = data.HashObject(reader, "tree")
+	oid, err = repo.Objects.Put(reader, "tree")
 	return oid, err
 }
 
-func Commit(msg string) (oid string, err error) {
-	oid, err = WriteTree(".")
+func (repo *Repository) Commit(msg string) (oid string, err error) {
+	if err := repo.CheckWorkTree(); err != nil {
+		return "", err
+	}
+	conflicts, err := repo.ReadMergeConflicts()
+	if err != nil {
+		return "", err
+	}
+	if len(conflicts) > 0 {
+		return "", errors.New(fmt.Sprintf("cannot commit with unmerged paths (%s); fix the conflicts and add them first", strings.Join(conflicts, ", ")))
+	}
+	oid, err = repo.WriteTreeFromIndex()
 	if err != nil {
 		return "", err
 	}
 
-	head, err := data.GetHead()
+	head, err := repo.GetHead()
+	if err != nil {
+		return "", err
+	}
+	parents := []string{}
+	if head != "" { // Head will be "" for 1st commit
+		parents = append(parents, head)
+	}
+	mergeHead, err := repo.GetRef(data.MERGE_HEAD, false)
+	if err != nil {
+		return "", err
+	}
+	if mergeHead.Value != "" {
+		parents = append(parents, mergeHead.Value)
+	}
+	author, err := repo.GetIdent(data.ROLE_AUTHOR)
+	if err != nil {
+		return "", err
+	}
+	committer, err := repo.GetIdent(data.ROLE_COMMITTER)
 	if err != nil {
 		return "", err
 	}
 	commit := CommitInfo{
-		Message: msg,
-		Parent:  head, // Head will be "" for 1st commit
-		Tree:    oid,
+		Message:   msg,
+		Parents:   parents,
+		Tree:      oid,
+		Author:    NewSignature(author),
+		Committer: NewSignature(committer),
+	}
+	oid, err = repo.hashMessage(&commit, "commit")
+	if err != nil {
+		return "", err
+	}
+	reason := "commit: "
+	if head == "" {
+		reason = "commit (initial): "
+	} else if mergeHead.Value != "" {
+		reason = "commit (merge): "
+	}
+	subject, _, _ := strings.Cut(msg, "\n")
+	// fails, rather than losing a commit, if HEAD moved while this one was made
+	err = repo.CompareAndSwapHead(head, oid, reason+subject)
+	if err == nil && mergeHead.Value != "" {
+		err = repo.DeleteRef(data.MERGE_HEAD, false)
+	}
+	return oid, err
+}
+
+// hashMessage stores msg as an object of type_: its length followed by the
+// marshalled message, or git's encoding of it in the git object format.
+func (repo *Repository) hashMessage(msg proto.Message, type_ string) (oid string, err error) {
+	if git, err := repo.isGitFormat(); err != nil {
+		return "", err
+	} else if git {
+		buf, err := encodeGitMessage(msg)
+		if err != nil {
+			return "", err
+		}
+		return repo.Objects.Put(bytes.NewReader(buf), type_)
+	}
+	msgBuf, err := proto.Marshal(msg)
+	if err != nil {
+		return "", err
 	}
-	commitBuf, err := proto.Marshal(&commit)
-	buf, err := uint64ToByteArray(uint64(len(commitBuf)))
+	buf, err := uint64ToByteArray(uint64(len(msgBuf)))
 	if err != nil {
 		return "", err
 	}
-	buf = append(buf, commitBuf...)
+	buf = append(buf, msgBuf...)
 	reader, writer := io.Pipe()
 	go func() {
 		defer writer.Close()
 		writer.Write(buf)
 	}()
-
-	oid, err = data.HashObject(reader, "commit")
-	if err == nil {
-		data.SetHead(oid)
-	}
-	return oid, err
+	return repo.Objects.Put(reader, type_)
 }
 
-func GetCommit(oid string) (CommitInfo, error) {
-	fh, err := data.GetObject(oid, "commit")
+// readMessage is the inverse of hashMessage.
+func (repo *Repository) readMessage(oid string, type_ string, msg proto.Message) error {
+	fh, err := repo.getObject(oid, type_)
 	if err != nil {
-		return CommitInfo{}, err
+		return err
 	}
 	defer fh.Close()
+	if git, err := repo.isGitFormat(); err != nil {
+		return err
+	} else if git {
+		return decodeGitMessage(fh, msg)
+	}
 	len, err := readUint64(fh)
 	if err != nil {
-		return CommitInfo{}, err
+		return err
 	}
 	buf := make([]byte, len)
-	_, err = fh.Read(buf)
+	_, err = io.ReadFull(fh, buf)
 	if err != nil {
-		return CommitInfo{}, err
+		return err
+	}
+	if err := expectEOF(fh, oid); err != nil {
+		return err
 	}
+	return proto.Unmarshal(buf, msg)
+}
 
+func (repo *Repository) GetCommit(oid string) (CommitInfo, error) {
 	commit := CommitInfo{}
-	err = proto.Unmarshal(buf, &commit)
+	err := repo.readMessage(oid, "commit", &commit)
 	return CommitInfo{
-		Parent:  commit.GetParent(),
-		Message: commit.GetMessage(),
-		Tree:    commit.GetTree(),
+		Parents:   commit.GetParents(),
+		Message:   commit.GetMessage(),
+		Tree:      commit.GetTree(),
+		Author:    commit.GetAuthor(),
+		Committer: commit.GetCommitter(),
 	}, err
 }
+
+func NewSignature(ident data.Ident) *Signature {
+	_, offset := ident.When.Zone()
+	return &Signature{
+		Name:     ident.Name,
+		Email:    ident.Email,
+		When:     ident.When.Unix(),
+		TzOffset: int32(offset / 60),
+	}
+}
+
+// Time returns when the signature was made, in the signer's timezone.
+func (sig *Signature) Time() time.Time {
+	zone := time.FixedZone("", int(sig.GetTzOffset())*60)
+	return time.Unix(sig.GetWhen(), 0).In(zone)
+}
@@ -0,0 +1,73 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+func (repo *Repository) IsBranch(name string) bool {
+	return name != "" && repo.RefExists(data.HEADS_PREFIX+name)
+}
+
+func (repo *Repository) CreateBranch(name string, oid string) error {
+	if err := data.CheckRefName(name); err != nil {
+		return err
+	}
+	if repo.IsBranch(name) {
+		return errors.New(fmt.Sprintf("a branch named '%s' already exists", name))
+	}
+	if oid == "" {
+		return errors.New(fmt.Sprintf("cannot create branch '%s': no commits yet", name))
+	}
+	return repo.UpdateRef(data.HEADS_PREFIX+name, data.RefValue{Value: oid}, false, "branch: Created from "+oid)
+}
+
+// GetBranchName returns the branch HEAD is attached to, or "" when detached.
+func (repo *Repository) GetBranchName() (string, error) {
+	head, err := repo.GetRef(data.HEAD, false)
+	if err != nil {
+		return "", err
+	}
+	if !head.Symbolic {
+		return "", nil
+	}
+	if !strings.HasPrefix(head.Value, data.HEADS_PREFIX) {
+		return "", errors.New(fmt.Sprintf("HEAD points outside of %s: %s", data.HEADS_PREFIX, head.Value))
+	}
+	return strings.TrimPrefix(head.Value, data.HEADS_PREFIX), nil
+}
+
+func (repo *Repository) IterBranchNames() ([]string, error) {
+	refs, err := repo.IterRefs(data.HEADS_PREFIX, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, strings.TrimPrefix(ref.Name, data.HEADS_PREFIX))
+	}
+	return names, nil
+}
+
+func (repo *Repository) DeleteBranch(name string) error {
+	if !repo.IsBranch(name) {
+		return errors.New(fmt.Sprintf("branch '%s' not found", name))
+	}
+	current, err := repo.GetBranchName()
+	if err != nil {
+		return err
+	}
+	if current == name {
+		return errors.New(fmt.Sprintf("cannot delete branch '%s': it is checked out", name))
+	}
+	other, err := repo.checkedOutAt(name)
+	if err != nil {
+		return err
+	} else if other != nil {
+		return errors.New(fmt.Sprintf("cannot delete branch '%s': it is checked out at %s", name, other.Path))
+	}
+	return repo.DeleteRef(data.HEADS_PREFIX+name, false)
+}
@@ -0,0 +1,222 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// hashWorkingFile returns the blob oid of the file at path in the worktree,
+// without storing it. exists is false when nothing is there; a directory
+// exists but has no oid.
+func (repo *Repository) hashWorkingFile(path string) (oid string, exists bool, err error) {
+	info, err := os.Lstat(repo.workPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(repo.workPath(path))
+		if err != nil {
+			return "", true, err
+		}
+		oid, err = repo.Objects.Hash(strings.NewReader(filepath.ToSlash(target)), "blob")
+		return oid, true, err
+	} else if !info.Mode().IsRegular() {
+		return "", true, nil
+	}
+	fh, err := os.Open(repo.workPath(path))
+	if err != nil {
+		return "", true, err
+	}
+	defer fh.Close()
+	oid, err = repo.Objects.Hash(fh, "blob")
+	return oid, true, err
+}
+
+// getCommitTree returns the tree of commit oid, or "" for an empty oid.
+func (repo *Repository) getCommitTree(oid string) (string, error) {
+	if oid == "" {
+		return "", nil
+	}
+	commit, err := repo.GetCommit(oid)
+	if err != nil {
+		return "", err
+	}
+	return commit.GetTree(), nil
+}
+
+// removeWorkingFile deletes path from the worktree, along with any parent
+// directories left empty.
+func (repo *Repository) removeWorkingFile(path string) error {
+	err := os.Remove(repo.workPath(path))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(filepath.FromSlash(path)); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(repo.workPath(dir))
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		os.Remove(repo.workPath(dir))
+	}
+	return nil
+}
+
+// updateWorkingTree moves the working directory and index from the current
+// tree to the target tree, whose files have the given modes, touching only
+// paths that differ between the two. Nothing is written if doing so would
+// lose uncommitted or untracked work.
+func (repo *Repository) updateWorkingTree(current map[string]string, target map[string]string, modes map[string]uint32, index map[string]*IndexEntry) error {
+	changed := []string{}
+	for path, oid := range current {
+		if target[path] != oid {
+			changed = append(changed, path)
+		} else if staged, ok := index[path]; ok && staged.GetMode() != modes[path] {
+			changed = append(changed, path) // only the mode changes
+		}
+	}
+	for path := range target {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+
+	conflicts := []string{}
+	for _, path := range changed {
+		oid, exists, err := repo.hashWorkingFile(path)
+		if err != nil {
+			return err
+		}
+		staged, isStaged := index[path]
+		if currentOid, tracked := current[path]; tracked {
+			_, kept := target[path]
+			if (exists && oid != currentOid) || (!exists && kept) || !isStaged || staged.GetOid() != currentOid {
+				conflicts = append(conflicts, path)
+			}
+		} else if (exists && oid != target[path]) || (isStaged && staged.GetOid() != target[path]) {
+			conflicts = append(conflicts, path)
+		}
+		if _, kept := target[path]; kept {
+			// a file we are about to write must not sit below a file that stays
+			for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+				info, err := os.Lstat(repo.workPath(dir))
+				if err == nil && !info.IsDir() {
+					if _, tracked := current[filepath.ToSlash(dir)]; !tracked || target[filepath.ToSlash(dir)] != "" {
+						conflicts = append(conflicts, path)
+					}
+					break
+				}
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return errors.New(fmt.Sprintf("your local changes would be overwritten: %s", strings.Join(conflicts, ", ")))
+	}
+
+	for _, path := range changed {
+		if _, kept := target[path]; !kept {
+			if err := repo.removeWorkingFile(path); err != nil {
+				return err
+			}
+			delete(index, path)
+		}
+	}
+	for _, path := range changed {
+		if oid, kept := target[path]; kept {
+			if err := repo.checkoutBlob(oid, path, modes[path]); err != nil {
+				return err
+			}
+			entry, err := repo.checkedOutEntry(path, oid, modes[path])
+			if err != nil {
+				return err
+			}
+			index[path] = entry
+		}
+	}
+	return nil
+}
+
+// Checkout switches the working directory and HEAD to a branch, or detaches
+// HEAD at any other commit.
+func (repo *Repository) Checkout(name string) error {
+	if err := repo.CheckWorkTree(); err != nil {
+		return err
+	}
+	var oid string
+	var headValue data.RefValue
+	if !repo.IsBranch(name) {
+		var err error
+		oid, err = repo.GetCommitOid(name)
+		if err != nil {
+			return err
+		}
+		headValue = data.RefValue{Value: oid}
+	} else {
+		other, err := repo.checkedOutAt(name)
+		if err != nil {
+			return err
+		} else if other != nil && other.GitDir != repo.GitDir {
+			return errors.New(fmt.Sprintf("'%s' is already checked out at %s", name, other.Path))
+		}
+		ref, err := repo.GetRef(data.HEADS_PREFIX+name, true)
+		if err != nil {
+			return err
+		}
+		oid = ref.Value
+		headValue = data.RefValue{Symbolic: true, Value: data.HEADS_PREFIX + name}
+	}
+	targetTree, err := repo.getCommitTree(oid)
+	if err != nil {
+		return err
+	}
+	head, err := repo.GetHead()
+	if err != nil {
+		return err
+	}
+	currentTree, err := repo.getCommitTree(head)
+	if err != nil {
+		return err
+	}
+
+	current, err := repo.getTreeMap(currentTree)
+	if err != nil {
+		return err
+	}
+	target, err := repo.getTreeMap(targetTree)
+	if err != nil {
+		return err
+	}
+	modes, err := repo.getTreeModes(targetTree)
+	if err != nil {
+		return err
+	}
+	lock, index, err := repo.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	err = repo.updateWorkingTree(current, target, modes, index)
+	if err != nil {
+		return err
+	}
+	err = repo.commitIndex(lock, index)
+	if err != nil {
+		return err
+	}
+	from, err := repo.GetBranchName()
+	if err != nil {
+		return err
+	} else if from == "" {
+		from = head
+	}
+	return repo.UpdateRef(data.HEAD, headValue, false, fmt.Sprintf("checkout: moving from %s to %s", from, name))
+}
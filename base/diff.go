@@ -0,0 +1,534 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const DIFF_CONTEXT int = 3
+
+// past this many edits, give up on a minimal diff and replace everything
+const MAX_DIFF_EDITS int = 10000
+
+type diffOp int
+
+const (
+	opEqual diffOp = iota
+	opDelete
+	opInsert
+)
+
+type diffEdit struct {
+	op   diffOp
+	aIdx int // position in a before this edit
+	bIdx int // position in b before this edit
+}
+
+// splitLines splits content after every newline. The last line lacks its
+// terminator when the content does not end in one.
+func splitLines(content []byte) []string {
+	lines := []string{}
+	for len(content) > 0 {
+		idx := bytes.IndexByte(content, '\n')
+		if idx < 0 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:idx+1]))
+		content = content[idx+1:]
+	}
+	return lines
+}
+
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, '\000') >= 0
+}
+
+// myersDiff computes a shortest edit script turning a into b, following
+// Myers' "An O(ND) Difference Algorithm and Its Variations". Common leading
+// and trailing lines are matched up front, which also keeps the alignment of
+// repeated lines stable.
+func myersDiff(a []string, b []string) []diffEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := []diffEdit{}
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, diffEdit{op: opEqual, aIdx: i, bIdx: i})
+	}
+	for _, edit := range myersDiffCore(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		edit.aIdx += prefix
+		edit.bIdx += prefix
+		edits = append(edits, edit)
+	}
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, diffEdit{op: opEqual, aIdx: len(a) - i, bIdx: len(b) - i})
+	}
+	return edits
+}
+
+func myersDiffCore(a []string, b []string) []diffEdit {
+	// compare interned ints rather than strings
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	n, m := len(a), len(b)
+	differ := &myersDiffer{a: intern(a), b: intern(b)}
+	size := 2*(n+m+1) + 1
+	differ.forward, differ.backward = make([]int, size), make([]int, size)
+
+	if !differ.diff(0, n, 0, m, MAX_DIFF_EDITS) {
+		edits := []diffEdit{}
+		for i := 0; i < n; i++ {
+			edits = append(edits, diffEdit{op: opDelete, aIdx: i, bIdx: 0})
+		}
+		for j := 0; j < m; j++ {
+			edits = append(edits, diffEdit{op: opInsert, aIdx: n, bIdx: j})
+		}
+		return edits
+	}
+
+	// the halves may interleave the deletions and insertions of a change;
+	// put the deletions first, as a single forward pass would
+	edits := differ.edits
+	for start := 0; start < len(edits); {
+		if edits[start].op == opEqual {
+			start++
+			continue
+		}
+		end := start
+		deleted := 0
+		for end < len(edits) && edits[end].op != opEqual {
+			if edits[end].op == opDelete {
+				deleted++
+			}
+			end++
+		}
+		aStart, bStart := edits[start].aIdx, edits[start].bIdx
+		for i := start; i < start+deleted; i++ {
+			edits[i] = diffEdit{op: opDelete, aIdx: aStart + i - start, bIdx: bStart}
+		}
+		for i := start + deleted; i < end; i++ {
+			edits[i] = diffEdit{op: opInsert, aIdx: aStart + deleted, bIdx: bStart + i - start - deleted}
+		}
+		start = end
+	}
+	return edits
+}
+
+// myersDiffer finds the edits with the linear space refinement of the
+// paper: the middle snake of a shortest path splits the problem in two, and
+// only the furthest reaching paths of the current edit count are kept.
+type myersDiffer struct {
+	a, b     []int
+	forward  []int // furthest x on each diagonal, from the start
+	backward []int // furthest distance back from the end on each diagonal
+	edits    []diffEdit
+}
+
+// diff appends the edits between a[aLo:aHi] and b[bLo:bHi], unless there are
+// more than limit of them.
+func (differ *myersDiffer) diff(aLo int, aHi int, bLo int, bHi int, limit int) bool {
+	for aLo < aHi && bLo < bHi && differ.a[aLo] == differ.b[bLo] {
+		differ.edits = append(differ.edits, diffEdit{op: opEqual, aIdx: aLo, bIdx: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && differ.a[aHi-1-suffix] == differ.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	if aLo == aHi {
+		for j := bLo; j < bHi; j++ {
+			differ.edits = append(differ.edits, diffEdit{op: opInsert, aIdx: aLo, bIdx: j})
+		}
+	} else if bLo == bHi {
+		for i := aLo; i < aHi; i++ {
+			differ.edits = append(differ.edits, diffEdit{op: opDelete, aIdx: i, bIdx: bLo})
+		}
+	} else {
+		// neither side is empty and the ends differ, so there are at least
+		// two edits and both halves are smaller problems
+		x, y, u, v, ok := differ.middleSnake(aLo, aHi, bLo, bHi, limit)
+		if !ok {
+			return false
+		}
+		// the halves cannot have more edits than the whole
+		differ.diff(aLo, x, bLo, y, limit)
+		for ; x < u; x, y = x+1, y+1 {
+			differ.edits = append(differ.edits, diffEdit{op: opEqual, aIdx: x, bIdx: y})
+		}
+		differ.diff(u, aHi, v, bHi, limit)
+	}
+
+	for i := 0; i < suffix; i++ {
+		differ.edits = append(differ.edits, diffEdit{op: opEqual, aIdx: aHi + i, bIdx: bHi + i})
+	}
+	return true
+}
+
+// middleSnake returns the snake from (x, y) to (u, v) in the middle of a
+// shortest edit script between a[aLo:aHi] and b[bLo:bHi], searching from
+// both ends at once. It gives up once the script would be longer than limit.
+func (differ *myersDiffer) middleSnake(aLo int, aHi int, bLo int, bHi int, limit int) (x int, y int, u int, v int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	offset := (n + m + 1) / 2
+	forward, backward := differ.forward, differ.backward
+	forward[offset+1], backward[offset+1] = 0, 0
+	for d := 0; d <= offset && 2*d-1 <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && differ.a[aLo+x] == differ.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			back := delta - k
+			if delta%2 != 0 && back >= -(d-1) && back <= d-1 && x+backward[offset+back] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y, 2*d-1 <= limit
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && differ.a[aHi-1-x] == differ.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			ahead := delta - k
+			if delta%2 == 0 && ahead >= -d && ahead <= d && x+forward[offset+ahead] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY, 2*d <= limit
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+func hunkRange(start int, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeDiffLine(w io.Writer, prefix string, line string) {
+	if strings.HasSuffix(line, "\n") {
+		fmt.Fprintf(w, "%s%s", prefix, line)
+	} else {
+		fmt.Fprintf(w, "%s%s\n\\ No newline at end of file\n", prefix, line)
+	}
+}
+
+// writeUnifiedHunks prints the edits between a and b as unified diff hunks.
+func writeUnifiedHunks(w io.Writer, a []string, b []string) {
+	edits := myersDiff(a, b)
+	changes := []int{}
+	for i, edit := range edits {
+		if edit.op != opEqual {
+			changes = append(changes, i)
+		}
+	}
+	for len(changes) > 0 {
+		// grow the hunk while the next change is close enough to share context
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*DIFF_CONTEXT {
+			last++
+		}
+		start := changes[0] - DIFF_CONTEXT
+		if start < 0 {
+			start = 0
+		}
+		end := changes[last] + DIFF_CONTEXT + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		changes = changes[last+1:]
+
+		aCount, bCount := 0, 0
+		for _, edit := range edits[start:end] {
+			if edit.op != opInsert {
+				aCount++
+			}
+			if edit.op != opDelete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(edits[start].aIdx, aCount), hunkRange(edits[start].bIdx, bCount))
+		for _, edit := range edits[start:end] {
+			switch edit.op {
+			case opEqual:
+				writeDiffLine(w, " ", a[edit.aIdx])
+			case opDelete:
+				writeDiffLine(w, "-", a[edit.aIdx])
+			case opInsert:
+				writeDiffLine(w, "+", b[edit.bIdx])
+			}
+		}
+	}
+}
+
+// diffSide is one side of a comparison: a path -> blob oid map, whose content
+// comes either from the object store or from the working directory, along
+// with the mode of each path.
+type diffSide struct {
+	files   map[string]string
+	modes   map[string]uint32
+	working bool
+}
+
+func (repo *Repository) readSide(side diffSide, path string) ([]byte, error) {
+	oid, ok := side.files[path]
+	if !ok {
+		return []byte{}, nil
+	}
+	if side.working {
+		return os.ReadFile(repo.workPath(path))
+	}
+	return repo.readBlob(oid)
+}
+
+func (repo *Repository) readBlob(oid string) ([]byte, error) {
+	fh, err := repo.getObject(oid, "blob")
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return io.ReadAll(fh)
+}
+
+func (repo *Repository) writeFileDiff(w io.Writer, path string, from diffSide, to diffSide) error {
+	fromContent, err := repo.readSide(from, path)
+	if err != nil {
+		return err
+	}
+	toContent, err := repo.readSide(to, path)
+	if err != nil {
+		return err
+	}
+	fromName, toName := "a/"+path, "b/"+path
+	fmt.Fprintf(w, "diff --ugit %s %s\n", fromName, toName)
+	fromMode, inFrom := from.modes[path]
+	toMode, inTo := to.modes[path]
+	if inFrom && inTo && fromMode != toMode {
+		fmt.Fprintf(w, "old mode %o\nnew mode %o\n", fromMode, toMode)
+		if bytes.Equal(fromContent, toContent) {
+			return nil
+		}
+	}
+	if _, ok := from.files[path]; !ok {
+		fmt.Fprintln(w, "new file")
+		fromName = "/dev/null"
+	}
+	if _, ok := to.files[path]; !ok {
+		fmt.Fprintln(w, "deleted file")
+		toName = "/dev/null"
+	}
+	if isBinary(fromContent) || isBinary(toContent) {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", fromName, toName)
+		return nil
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+	writeUnifiedHunks(w, splitLines(fromContent), splitLines(toContent))
+	return nil
+}
+
+func (repo *Repository) writeDiff(w io.Writer, from diffSide, to diffSide) error {
+	changes := diffTreeMaps(from.files, to.files, from.modes, to.modes)
+	paths := append(append(append([]string{}, changes.Added...), changes.Modified...), changes.Deleted...)
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := repo.writeFileDiff(w, path, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workingDiffSide hashes the working copy of every indexed file, trusting
+// the index for files whose stat information has not changed.
+func (repo *Repository) workingDiffSide(index map[string]*IndexEntry) (diffSide, error) {
+	side := diffSide{files: map[string]string{}, modes: map[string]uint32{}, working: true}
+	for path, entry := range index {
+		info, err := os.Lstat(repo.workPath(path))
+		if errors.Is(err, os.ErrNotExist) || (err == nil && !isWorkingFile(info)) {
+			continue
+		} else if err != nil {
+			return diffSide{}, err
+		}
+		side.modes[path] = workingMode(entry, info)
+		if isStatClean(entry, info) {
+			side.files[path] = entry.GetOid()
+			continue
+		}
+		oid, _, err := repo.hashWorkingFile(path)
+		if err != nil {
+			return diffSide{}, err
+		}
+		side.files[path] = oid
+	}
+	return side, nil
+}
+
+func indexDiffSide(index map[string]*IndexEntry) diffSide {
+	side := diffSide{files: map[string]string{}, modes: map[string]uint32{}}
+	for path, entry := range index {
+		side.files[path] = entry.GetOid()
+		side.modes[path] = indexMode(entry)
+	}
+	return side
+}
+
+// GetTreeOid resolves the name of a tree, or of a commit or tag pointing at
+// one, and returns the tree.
+func (repo *Repository) GetTreeOid(name string) (string, error) {
+	oid, err := repo.RevParse(name)
+	if err != nil {
+		return "", err
+	}
+	return repo.Peel(oid, "tree")
+}
+
+// DiffTrees writes the unified diff between two trees (or commits).
+func (repo *Repository) DiffTrees(w io.Writer, fromOid string, toOid string) error {
+	from, err := repo.treeSide(fromOid)
+	if err != nil {
+		return err
+	}
+	to, err := repo.treeSide(toOid)
+	if err != nil {
+		return err
+	}
+	return repo.writeDiff(w, from, to)
+}
+
+// DiffWorkingTree writes the unified diff between a tree (or commit) and the
+// tracked files in the working directory.
+func (repo *Repository) DiffWorkingTree(w io.Writer, fromOid string) error {
+	from, err := repo.treeSide(fromOid)
+	if err != nil {
+		return err
+	}
+	index, err := repo.readIndex()
+	if err != nil {
+		return err
+	}
+	to, err := repo.workingDiffSide(index)
+	if err != nil {
+		return err
+	}
+	return repo.writeDiff(w, from, to)
+}
+
+// DiffIndex writes the unified diff between the index and the working
+// directory, i.e. the changes not staged yet.
+func (repo *Repository) DiffIndex(w io.Writer) error {
+	index, err := repo.readIndex()
+	if err != nil {
+		return err
+	}
+	to, err := repo.workingDiffSide(index)
+	if err != nil {
+		return err
+	}
+	return repo.writeDiff(w, indexDiffSide(index), to)
+}
+
+// DiffCached writes the unified diff between a tree (or commit) and the
+// index, i.e. what would be committed.
+func (repo *Repository) DiffCached(w io.Writer, fromOid string) error {
+	from, err := repo.treeSide(fromOid)
+	if err != nil {
+		return err
+	}
+	index, err := repo.readIndex()
+	if err != nil {
+		return err
+	}
+	return repo.writeDiff(w, from, indexDiffSide(index))
+}
+
+// DiffCommit writes the changes a commit introduced over its parent.
+func (repo *Repository) DiffCommit(w io.Writer, oid string) error {
+	commit, err := repo.GetCommit(oid)
+	if err != nil {
+		return err
+	}
+	parent := ""
+	if len(commit.GetParents()) > 0 {
+		parent = commit.GetParents()[0]
+	}
+	parentTree, err := repo.getCommitTree(parent)
+	if err != nil {
+		return err
+	}
+	from, err := repo.treeSide(parentTree)
+	if err != nil {
+		return err
+	}
+	to, err := repo.treeSide(commit.GetTree())
+	if err != nil {
+		return err
+	}
+	return repo.writeDiff(w, from, to)
+}
+
+// treeSide flattens a tree or commit; "" stands for the empty tree.
+func (repo *Repository) treeSide(oid string) (diffSide, error) {
+	if oid == "" {
+		return diffSide{files: map[string]string{}, modes: map[string]uint32{}}, nil
+	}
+	tree, err := repo.GetTreeOid(oid)
+	if err != nil {
+		return diffSide{}, err
+	}
+	files, err := repo.getTreeMap(tree)
+	if err != nil {
+		return diffSide{}, err
+	}
+	modes, err := repo.getTreeModes(tree)
+	return diffSide{files: files, modes: modes}, err
+}
@@ -0,0 +1,186 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func lines(s string) []string {
+	return splitLines([]byte(s))
+}
+
+// applyEdits checks that edits turn a into b, returning how many lines they
+// delete or insert.
+func applyEdits(t *testing.T, a []string, b []string, edits []diffEdit) int {
+	t.Helper()
+	aIdx, bIdx, count := 0, 0, 0
+	for _, edit := range edits {
+		if edit.aIdx != aIdx || edit.bIdx != bIdx {
+			t.Fatalf("edit %+v is not at a[%d], b[%d]", edit, aIdx, bIdx)
+		}
+		switch edit.op {
+		case opEqual:
+			if a[aIdx] != b[bIdx] {
+				t.Fatalf("a[%d] %q is kept as b[%d] %q", aIdx, a[aIdx], bIdx, b[bIdx])
+			}
+			aIdx++
+			bIdx++
+		case opDelete:
+			aIdx++
+			count++
+		case opInsert:
+			bIdx++
+			count++
+		}
+	}
+	if aIdx != len(a) || bIdx != len(b) {
+		t.Fatalf("edits stop at a[%d], b[%d] of %d, %d lines", aIdx, bIdx, len(a), len(b))
+	}
+	return count
+}
+
+// lcsLength is the length of the longest common subsequence, the slow way.
+func lcsLength(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestMyersDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		edits int
+	}{
+		{"identical", "a\nb\nc\n", "a\nb\nc\n", 0},
+		{"both empty", "", "", 0},
+		{"from empty", "", "a\nb\n", 2},
+		{"to empty", "a\nb\n", "", 2},
+		{"insert in the middle", "a\nc\n", "a\nb\nc\n", 1},
+		{"delete in the middle", "a\nb\nc\n", "a\nc\n", 1},
+		{"replace a line", "a\nb\nc\n", "a\nx\nc\n", 2},
+		{"the paper's example", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"missing newline", "a\nb", "a\nb\n", 2},
+		{"repeated lines", "x\nx\nx\n", "x\nx\n", 1},
+		{"moved block", "a\nb\nc\nd\ne\n", "d\ne\na\nb\nc\n", 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := lines(test.a), lines(test.b)
+			if count := applyEdits(t, a, b, myersDiff(a, b)); count != test.edits {
+				t.Fatalf("%d edits instead of %d", count, test.edits)
+			}
+		})
+	}
+}
+
+func TestMyersDiffIsMinimal(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	side := func() []string {
+		out := make([]string, random.Intn(16))
+		for i := range out {
+			out[i] = string(rune('a'+random.Intn(4))) + "\n"
+		}
+		return out
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := side(), side()
+		count := applyEdits(t, a, b, myersDiff(a, b))
+		if minimal := len(a) + len(b) - 2*lcsLength(a, b); count != minimal {
+			t.Fatalf("%q -> %q: %d edits instead of %d", a, b, count, minimal)
+		}
+	}
+}
+
+func TestMyersDiffGivesUp(t *testing.T) {
+	a, b := []string{}, []string{}
+	for i := 0; i < MAX_DIFF_EDITS; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	a, b = append(a, "same\n"), append([]string{"same\n"}, b...)
+	edits := myersDiff(a, b)
+	if count := applyEdits(t, a, b, edits); count != len(a)+len(b) {
+		t.Fatalf("%d edits instead of replacing all %d lines", count, len(a)+len(b))
+	}
+}
+
+func TestWriteUnifiedHunks(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"no changes", "a\n", "a\n", ""},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"deletions before insertions", "a\nb\n", "c\nd\n", "@@ -1,2 +1,2 @@\n-a\n-b\n+c\n+d\n"},
+		{"new file", "", "a\n", "@@ -0,0 +1 @@\n+a\n"},
+		{"missing newline", "a", "b", "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			"shared context",
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"x\n2\n3\n4\n5\n6\ny\n",
+			"@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeUnifiedHunks(&out, lines(test.a), lines(test.b))
+			if out.String() != test.want {
+				t.Fatalf("got\n%s\nwant\n%s", out.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestDiffShowsModeChanges(t *testing.T) {
+	repo, _ := newMemoryRepository(t)
+	writeWorkingFile(t, repo, "a", "one\n")
+	if err := repo.Add([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(repo.workPath("a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	status, err := repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(status.Unstaged.Modified, " ") != "a" {
+		t.Fatalf("the chmod is not seen: %+v", status)
+	}
+	var out bytes.Buffer
+	if err := repo.DiffIndex(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "diff --ugit a/a b/a\nold mode 100644\nnew mode 100755\n"; out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
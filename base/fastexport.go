@@ -0,0 +1,267 @@
+package base
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// Streams in git's fast-import format, so history can be moved to and from
+// any tool that speaks it. Every blob and commit gets a mark (":1", ":2"...)
+// that later commands refer to it by.
+
+// UNKNOWN_SIGNATURE stands in for commits made before authors were recorded,
+// since fast-import insists on a committer.
+const UNKNOWN_SIGNATURE string = "unknown <unknown> 0 +0000"
+
+type fastExporter struct {
+	repo  *Repository
+	w     *bufio.Writer
+	marks map[string]int // oid -> mark
+}
+
+func (exp *fastExporter) mark(oid string) int {
+	if mark, ok := exp.marks[oid]; ok {
+		return mark
+	}
+	exp.marks[oid] = len(exp.marks) + 1
+	return exp.marks[oid]
+}
+
+// quotePath quotes paths git would not accept bare.
+func quotePath(path string) string {
+	if strings.ContainsAny(path, "\"\\\n") {
+		return strconv.Quote(path)
+	}
+	return path
+}
+
+func exportSignature(sig *Signature) string {
+	if sig == nil {
+		return UNKNOWN_SIGNATURE
+	}
+	return formatGitSignature(sig)
+}
+
+func (exp *fastExporter) writeData(content []byte) {
+	fmt.Fprintf(exp.w, "data %d\n", len(content))
+	exp.w.Write(content)
+	exp.w.WriteString("\n")
+}
+
+func (exp *fastExporter) exportBlob(oid string) error {
+	if _, done := exp.marks[oid]; done {
+		return nil
+	}
+	fh, err := exp.repo.getObject(oid, "blob")
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	content, err := io.ReadAll(fh)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(exp.w, "blob\nmark :%d\n", exp.mark(oid))
+	exp.writeData(content)
+	return nil
+}
+
+func (repo *Repository) getTreeEntries(commitOid string) (map[string]tupleOidPath, error) {
+	entries := map[string]tupleOidPath{}
+	if commitOid == "" {
+		return entries, nil
+	}
+	tree, err := repo.getCommitTree(commitOid)
+	if err != nil {
+		return nil, err
+	}
+	list, err := repo.GetTree(tree, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, tuple := range list {
+		entries[filepath.ToSlash(tuple.path)] = tuple
+	}
+	return entries, nil
+}
+
+// exportCommit writes the blobs a commit adds, then the commit itself as a
+// set of changes against its first parent.
+func (exp *fastExporter) exportCommit(ref string, oid string, commit *CommitInfo) error {
+	parent := ""
+	if len(commit.GetParents()) > 0 {
+		parent = commit.GetParents()[0]
+	}
+	before, err := exp.repo.getTreeEntries(parent)
+	if err != nil {
+		return err
+	}
+	after, err := exp.repo.getTreeEntries(oid)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(before)+len(after))
+	for path := range after {
+		paths = append(paths, path)
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := []string{}
+	for _, path := range paths {
+		old, inBefore := before[path]
+		cur, inAfter := after[path]
+		if !inAfter {
+			changes = append(changes, fmt.Sprintf("D %s", quotePath(path)))
+		} else if !inBefore || old.oid != cur.oid || old.mode != cur.mode {
+			if err := exp.exportBlob(cur.oid); err != nil {
+				return err
+			}
+			changes = append(changes, fmt.Sprintf("M %o :%d %s", cur.mode, exp.mark(cur.oid), quotePath(path)))
+		}
+	}
+
+	fmt.Fprintf(exp.w, "commit %s\nmark :%d\n", ref, exp.mark(oid))
+	if commit.GetAuthor() != nil {
+		fmt.Fprintf(exp.w, "author %s\n", exportSignature(commit.GetAuthor()))
+	}
+	fmt.Fprintf(exp.w, "committer %s\n", exportSignature(commit.GetCommitter()))
+	exp.writeData([]byte(commit.GetMessage()))
+	for i, parent := range commit.GetParents() {
+		if i == 0 {
+			fmt.Fprintf(exp.w, "from :%d\n", exp.mark(parent))
+		} else {
+			fmt.Fprintf(exp.w, "merge :%d\n", exp.mark(parent))
+		}
+	}
+	for _, change := range changes {
+		fmt.Fprintln(exp.w, change)
+	}
+	exp.w.WriteString("\n")
+	return nil
+}
+
+// exportHistory writes every commit leading to tip that has not been written
+// yet, parents first. Returns whether anything was written.
+func (exp *fastExporter) exportHistory(ref string, tip string) (bool, error) {
+	parsed := map[string]*CommitInfo{}
+	stack := []string{tip}
+	wrote := false
+	for len(stack) > 0 {
+		oid := stack[len(stack)-1]
+		if _, done := exp.marks[oid]; done {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		commit, ok := parsed[oid]
+		if !ok {
+			info, err := exp.repo.GetCommit(oid)
+			if err != nil {
+				return wrote, err
+			}
+			commit = &info
+			parsed[oid] = commit
+		}
+		pending := false
+		for _, parent := range commit.GetParents() {
+			if _, done := exp.marks[parent]; !done {
+				stack = append(stack, parent)
+				pending = true
+			}
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		if err := exp.exportCommit(ref, oid, commit); err != nil {
+			return wrote, err
+		}
+		delete(parsed, oid)
+		wrote = true
+	}
+	return wrote, nil
+}
+
+// exportRef works out the full name of a branch, tag or ref. HEAD stands
+// for the branch it points at, if any.
+func (repo *Repository) exportRef(name string) (string, error) {
+	if name == data.HEAD || name == "@" {
+		head, err := repo.GetRef(data.HEAD, false)
+		if err != nil {
+			return "", err
+		}
+		if head.Symbolic {
+			return head.Value, nil
+		}
+		return data.HEAD, nil
+	} else if repo.IsBranch(name) {
+		return data.HEADS_PREFIX + name, nil
+	} else if repo.IsTag(name) {
+		return data.TAGS_PREFIX + name, nil
+	} else if strings.HasPrefix(name, "refs/") && repo.RefExists(name) {
+		return name, nil
+	}
+	return "", errors.New(fmt.Sprintf("'%s' is not a branch, tag or ref", name))
+}
+
+// FastExport writes the history of each of the named refs (HEAD by default)
+// to w as a fast-import stream.
+func (repo *Repository) FastExport(w io.Writer, names []string) error {
+	if len(names) == 0 {
+		names = []string{data.HEAD}
+	}
+	exp := &fastExporter{repo: repo, w: bufio.NewWriter(w), marks: map[string]int{}}
+	for _, name := range names {
+		ref, err := repo.exportRef(name)
+		if err != nil {
+			return err
+		}
+		value, err := repo.GetRef(ref, true)
+		if err != nil {
+			return err
+		}
+		if value.Value == "" {
+			return errors.New(fmt.Sprintf("'%s' has no commits yet", name))
+		}
+		tip, err := repo.Peel(value.Value, "commit")
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(ref, data.TAGS_PREFIX) && tip != value.Value {
+			if _, err := exp.exportHistory(ref, tip); err != nil {
+				return err
+			}
+			tag, err := repo.GetTag(value.Value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(exp.w, "tag %s\nfrom :%d\n", strings.TrimPrefix(ref, data.TAGS_PREFIX), exp.mark(tip))
+			if tag.GetTagger() != nil {
+				fmt.Fprintf(exp.w, "tagger %s\n", exportSignature(tag.GetTagger()))
+			}
+			exp.writeData([]byte(tag.GetMessage()))
+			continue
+		}
+		wrote, err := exp.exportHistory(ref, tip)
+		if err != nil {
+			return err
+		}
+		if !wrote {
+			fmt.Fprintf(exp.w, "reset %s\nfrom :%d\n\n", ref, exp.mark(tip))
+		}
+	}
+	exp.w.WriteString("done\n")
+	return exp.w.Flush()
+}
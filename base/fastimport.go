@@ -0,0 +1,550 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// FastImportResult summarizes what FastImport did.
+type FastImportResult struct {
+	Blobs   int
+	Commits int
+	Tags    int
+	Refs    []data.NamedRef // the refs written
+}
+
+type fastImporter struct {
+	repo     *Repository
+	r        *bufio.Reader
+	line     string // a line read ahead, if pending
+	pending  bool
+	marks    map[string]string // ":mark" -> oid
+	branches map[string]string // branches written by this stream -> oid
+	order    []string          // branch names in the order first written
+	result   FastImportResult
+}
+
+// next returns the next line that is not a comment, or io.EOF.
+func (imp *fastImporter) next() (string, error) {
+	if imp.pending {
+		imp.pending = false
+		return imp.line, nil
+	}
+	for {
+		line, err := imp.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		} else if err != nil && err != io.EOF {
+			return "", err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+}
+
+func (imp *fastImporter) unread(line string) {
+	imp.line, imp.pending = line, true
+}
+
+// optional consumes the next line if it starts with prefix, returning the
+// rest of it.
+func (imp *fastImporter) optional(prefix string) (value string, found bool, err error) {
+	line, err := imp.next()
+	if err == io.EOF {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	if strings.HasPrefix(line, prefix) {
+		return strings.TrimPrefix(line, prefix), true, nil
+	}
+	imp.unread(line)
+	return "", false, nil
+}
+
+// readData reads a "data <count>" or "data <<DELIMITER" block.
+func (imp *fastImporter) readData() ([]byte, error) {
+	line, err := imp.next()
+	if err == io.EOF {
+		return nil, errors.New("expected data, got the end of the stream")
+	} else if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "data ") {
+		return nil, errors.New(fmt.Sprintf("expected data, got '%s'", line))
+	}
+	arg := strings.TrimPrefix(line, "data ")
+	if strings.HasPrefix(arg, "<<") {
+		delim := strings.TrimPrefix(arg, "<<")
+		var buf bytes.Buffer
+		for {
+			line, err := imp.r.ReadString('\n')
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("missing data delimiter %s", delim))
+			}
+			if strings.TrimSuffix(line, "\n") == delim {
+				return buf.Bytes(), nil
+			}
+			buf.WriteString(line)
+		}
+	}
+	size, err := strconv.Atoi(arg)
+	if err != nil || size < 0 {
+		return nil, errors.New(fmt.Sprintf("bad data length '%s'", arg))
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(imp.r, content); err != nil {
+		return nil, err
+	}
+	// an LF may follow the data
+	if b, err := imp.r.Peek(1); err == nil && b[0] == '\n' {
+		imp.r.ReadByte()
+	}
+	return content, nil
+}
+
+// resolve looks up a mark, an oid, a branch written by the stream or any
+// revision of the repository.
+func (imp *fastImporter) resolve(name string) (string, error) {
+	if strings.HasPrefix(name, ":") {
+		oid, ok := imp.marks[name]
+		if !ok {
+			return "", errors.New(fmt.Sprintf("mark %s not declared", name))
+		}
+		return oid, nil
+	}
+	if oid, ok := imp.branches[name]; ok {
+		return oid, nil
+	}
+	return imp.repo.RevParse(name)
+}
+
+// unquotePath reverses the C style quoting git applies to unusual paths.
+func unquotePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "\"") {
+		return path, nil
+	}
+	return strconv.Unquote(path)
+}
+
+// splitPath takes a possibly quoted path off the front of s.
+func splitPath(s string) (path string, rest string, err error) {
+	if !strings.HasPrefix(s, "\"") {
+		path, rest, _ = strings.Cut(s, " ")
+		return path, rest, nil
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '"' {
+			path, err = strconv.Unquote(s[:i+1])
+			return path, strings.TrimPrefix(s[i+1:], " "), err
+		}
+	}
+	return "", "", errors.New(fmt.Sprintf("unterminated path %s", s))
+}
+
+// checkRef refuses the refs a stream may not update: anything but HEAD and
+// valid names under refs/.
+func checkRef(ref string) error {
+	if ref == data.HEAD {
+		return nil
+	}
+	if !strings.HasPrefix(ref, "refs/") {
+		return errors.New(fmt.Sprintf("'%s' is not a valid ref", ref))
+	}
+	return data.CheckRefName(ref)
+}
+
+func (imp *fastImporter) setBranch(ref string, oid string) {
+	if _, ok := imp.branches[ref]; !ok {
+		imp.order = append(imp.order, ref)
+	}
+	imp.branches[ref] = oid
+}
+
+func (imp *fastImporter) importBlob() error {
+	mark, _, err := imp.optional("mark ")
+	if err != nil {
+		return err
+	}
+	if _, _, err := imp.optional("original-oid "); err != nil {
+		return err
+	}
+	content, err := imp.readData()
+	if err != nil {
+		return err
+	}
+	oid, err := imp.repo.Objects.Put(bytes.NewReader(content), "blob")
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		imp.marks[mark] = oid
+	}
+	imp.result.Blobs++
+	return nil
+}
+
+// fileChange applies one of the M, D, C, R or deleteall commands of a commit
+// to its files.
+func (imp *fastImporter) fileChange(files map[string]*IndexEntry, line string) error {
+	if line == "deleteall" {
+		for path := range files {
+			delete(files, path)
+		}
+		return nil
+	}
+	op, args, _ := strings.Cut(line, " ")
+	switch op {
+	case "M":
+		fields := strings.SplitN(args, " ", 3)
+		if len(fields) != 3 {
+			return errors.New(fmt.Sprintf("bad filemodify '%s'", line))
+		}
+		mode, err := strconv.ParseUint(fields[0], 8, 32)
+		if err != nil {
+			return errors.New(fmt.Sprintf("bad mode in '%s'", line))
+		}
+		switch uint32(mode) {
+		case 0644:
+			mode = uint64(MODE_FILE)
+		case 0755:
+			mode = uint64(MODE_EXECUTABLE)
+		case MODE_FILE, MODE_EXECUTABLE, MODE_SYMLINK:
+		default:
+			return errors.New(fmt.Sprintf("unsupported mode %s in '%s'", fields[0], line))
+		}
+		path, err := unquotePath(fields[2])
+		if err != nil {
+			return err
+		}
+		if err := checkPath(path); err != nil {
+			return err
+		}
+		var oid string
+		if fields[1] == "inline" {
+			content, err := imp.readData()
+			if err != nil {
+				return err
+			}
+			oid, err = imp.repo.Objects.Put(bytes.NewReader(content), "blob")
+			if err != nil {
+				return err
+			}
+			imp.result.Blobs++
+		} else if oid, err = imp.resolve(fields[1]); err != nil {
+			return err
+		}
+		files[path] = &IndexEntry{Path: path, Oid: oid, Mode: uint32(mode)}
+	case "D":
+		path, err := unquotePath(args)
+		if err != nil {
+			return err
+		}
+		if err := checkPath(path); err != nil {
+			return err
+		}
+		for indexed := range files {
+			if isUnder(indexed, path) {
+				delete(files, indexed)
+			}
+		}
+	case "C", "R":
+		src, dst, err := splitPath(args)
+		if err != nil {
+			return err
+		}
+		if dst, err = unquotePath(dst); err != nil {
+			return err
+		}
+		if err := checkPath(src); err != nil {
+			return err
+		}
+		if err := checkPath(dst); err != nil {
+			return err
+		}
+		matched := []*IndexEntry{}
+		for indexed, entry := range files {
+			if isUnder(indexed, src) {
+				matched = append(matched, entry)
+			}
+		}
+		if len(matched) == 0 {
+			return errors.New(fmt.Sprintf("path %s not in branch", src))
+		}
+		for _, entry := range matched {
+			if op == "R" {
+				delete(files, entry.GetPath())
+			}
+		}
+		for _, entry := range matched {
+			moved := dst + strings.TrimPrefix(entry.GetPath(), src)
+			files[moved] = &IndexEntry{Path: moved, Oid: entry.GetOid(), Mode: entry.GetMode()}
+		}
+	default:
+		return errors.New(fmt.Sprintf("unsupported file command '%s'", line))
+	}
+	return nil
+}
+
+func (imp *fastImporter) importCommit(ref string) error {
+	if err := checkRef(ref); err != nil {
+		return err
+	}
+	mark, _, err := imp.optional("mark ")
+	if err != nil {
+		return err
+	}
+	if _, _, err := imp.optional("original-oid "); err != nil {
+		return err
+	}
+	var author, committer *Signature
+	if value, found, err := imp.optional("author "); err != nil {
+		return err
+	} else if found {
+		if author, err = parseGitSignature(value); err != nil {
+			return err
+		}
+	}
+	value, found, err := imp.optional("committer ")
+	if err != nil {
+		return err
+	} else if !found {
+		return errors.New(fmt.Sprintf("commit to %s has no committer", ref))
+	}
+	if committer, err = parseGitSignature(value); err != nil {
+		return err
+	}
+	if author == nil {
+		author = committer
+	}
+	if _, _, err := imp.optional("encoding "); err != nil {
+		return err
+	}
+	message, err := imp.readData()
+	if err != nil {
+		return err
+	}
+
+	parents := []string{}
+	if from, found, err := imp.optional("from "); err != nil {
+		return err
+	} else if found {
+		parent, err := imp.resolve(from)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, parent)
+	} else if oid, ok := imp.branches[ref]; ok {
+		parents = append(parents, oid)
+	}
+	for {
+		merge, found, err := imp.optional("merge ")
+		if err != nil {
+			return err
+		} else if !found {
+			break
+		}
+		parent, err := imp.resolve(merge)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, parent)
+	}
+
+	files := map[string]*IndexEntry{}
+	if len(parents) > 0 {
+		tree, err := imp.repo.getCommitTree(parents[0])
+		if err != nil {
+			return err
+		}
+		treeEntries, err := imp.repo.GetTree(tree, "")
+		if err != nil {
+			return err
+		}
+		for _, tuple := range treeEntries {
+			path := filepath.ToSlash(tuple.path)
+			files[path] = &IndexEntry{Path: path, Oid: tuple.oid, Mode: tuple.mode}
+		}
+	}
+	for {
+		line, err := imp.next()
+		if err == io.EOF || (err == nil && line == "") {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "M ") && !strings.HasPrefix(line, "D ") && !strings.HasPrefix(line, "C ") &&
+			!strings.HasPrefix(line, "R ") && line != "deleteall" {
+			imp.unread(line)
+			break
+		}
+		if err := imp.fileChange(files, line); err != nil {
+			return err
+		}
+	}
+
+	tree, err := imp.repo.writeIndexTree(sortedIndexEntries(files), "")
+	if err != nil {
+		return err
+	}
+	oid, err := imp.repo.hashMessage(&CommitInfo{
+		Message:   string(message),
+		Tree:      tree,
+		Parents:   parents,
+		Author:    author,
+		Committer: committer,
+	}, "commit")
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		imp.marks[mark] = oid
+	}
+	imp.setBranch(ref, oid)
+	imp.result.Commits++
+	return nil
+}
+
+func (imp *fastImporter) importTag(name string) error {
+	if err := data.CheckRefName(name); err != nil {
+		return err
+	}
+	if _, _, err := imp.optional("mark "); err != nil {
+		return err
+	}
+	from, found, err := imp.optional("from ")
+	if err != nil {
+		return err
+	} else if !found {
+		return errors.New(fmt.Sprintf("tag %s has no from", name))
+	}
+	object, err := imp.resolve(from)
+	if err != nil {
+		return err
+	}
+	if _, _, err := imp.optional("original-oid "); err != nil {
+		return err
+	}
+	var tagger *Signature
+	if value, found, err := imp.optional("tagger "); err != nil {
+		return err
+	} else if found {
+		if tagger, err = parseGitSignature(value); err != nil {
+			return err
+		}
+	}
+	message, err := imp.readData()
+	if err != nil {
+		return err
+	}
+	type_, err := imp.repo.getObjectType(object)
+	if err != nil {
+		return err
+	}
+	oid, err := imp.repo.hashMessage(&TagInfo{
+		Object:  object,
+		Type_:   type_,
+		Tag:     name,
+		Tagger:  tagger,
+		Message: string(message),
+	}, "tag")
+	if err != nil {
+		return err
+	}
+	imp.setBranch(data.TAGS_PREFIX+name, oid)
+	imp.result.Tags++
+	return nil
+}
+
+func (imp *fastImporter) importReset(ref string) error {
+	if err := checkRef(ref); err != nil {
+		return err
+	}
+	from, found, err := imp.optional("from ")
+	if err != nil {
+		return err
+	}
+	if !found {
+		delete(imp.branches, ref)
+		return nil
+	}
+	oid, err := imp.resolve(from)
+	if err != nil {
+		return err
+	}
+	imp.setBranch(ref, oid)
+	return nil
+}
+
+// FastImport reads a fast-import stream, storing its blobs, commits and tags,
+// and points the refs it names at them once the whole stream has been read.
+func (repo *Repository) FastImport(r io.Reader) (FastImportResult, error) {
+	imp := &fastImporter{
+		repo:     repo,
+		r:        bufio.NewReader(r),
+		marks:    map[string]string{},
+		branches: map[string]string{},
+	}
+	for {
+		line, err := imp.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return imp.result, err
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "":
+		case "blob":
+			err = imp.importBlob()
+		case "commit":
+			err = imp.importCommit(arg)
+		case "tag":
+			err = imp.importTag(arg)
+		case "reset":
+			err = imp.importReset(arg)
+		case "feature", "option", "progress", "checkpoint":
+		case "done":
+			return imp.updateRefs()
+		default:
+			err = errors.New(fmt.Sprintf("unsupported command '%s'", line))
+		}
+		if err != nil {
+			return imp.result, err
+		}
+	}
+	return imp.updateRefs()
+}
+
+func (imp *fastImporter) updateRefs() (FastImportResult, error) {
+	for _, ref := range imp.order {
+		oid, ok := imp.branches[ref]
+		if !ok {
+			continue
+		}
+		value := data.RefValue{Value: oid}
+		if ref == data.HEAD {
+			if err := imp.repo.SetHead(oid, "fast-import"); err != nil {
+				return imp.result, err
+			}
+		} else if err := checkRef(ref); err != nil {
+			return imp.result, err
+		} else if err := imp.repo.UpdateRef(ref, value, false, "fast-import"); err != nil {
+			return imp.result, err
+		}
+		imp.result.Refs = append(imp.result.Refs, data.NamedRef{Name: ref, Value: value})
+	}
+	return imp.result, nil
+}
@@ -0,0 +1,236 @@
+package base
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fastImportCommit = `commit %s
+committer Test <test@example.com> 1700000000 +0000
+data 4
+msg
+M 100644 inline f
+data 4
+one
+
+`
+
+func TestFastImportRefusesTraversingRefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{"commit out of the git dir", strings.Replace(fastImportCommit, "%s", "refs/../../escaped", 1)},
+		{"commit over the config", strings.Replace(fastImportCommit, "%s", "refs/../config", 1)},
+		{"commit with a dot component", strings.Replace(fastImportCommit, "%s", "refs/heads/./x", 1)},
+		{"commit outside refs", strings.Replace(fastImportCommit, "%s", "config", 1)},
+		{"reset over the config", strings.Replace(fastImportCommit, "%s", "refs/heads/master", 1) + "reset refs/../config\nfrom refs/heads/master\n"},
+		{"tag out of refs/tags", strings.Replace(fastImportCommit, "%s", "refs/heads/master", 1) + "tag ../../config\nfrom refs/heads/master\ndata 0\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, _ := newMemoryRepository(t)
+			config, err := os.ReadFile(filepath.Join(repo.GitDir, "config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.FastImport(strings.NewReader(test.stream)); err == nil {
+				t.Fatal("the stream was imported")
+			}
+			if _, err := os.Stat(filepath.Join(repo.WorkTree, "escaped")); err == nil {
+				t.Fatal("a ref was written outside the git dir")
+			}
+			after, err := os.ReadFile(filepath.Join(repo.GitDir, "config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(after) != string(config) {
+				t.Fatalf("the config was overwritten with %q", after)
+			}
+		})
+	}
+}
+
+const fastImportStream = `# a comment
+blob
+mark :1
+data 6
+hello
+
+commit refs/heads/main
+mark :2
+author A <a@example.com> 1700000000 +0000
+committer C <c@example.com> 1700000001 +0130
+data <<END
+first
+END
+M 100644 :1 a
+M 755 inline bin/run
+data 3
+sh
+
+M 120000 inline link
+data 1
+aM 100644 inline "sp ace\tq"
+data 2
+q
+
+commit refs/heads/main
+committer C <c@example.com> 1700000002 +0000
+data 6
+second
+from :2
+C a b
+R bin/run tools/run
+D link
+
+reset refs/heads/other
+from :2
+
+tag v1
+from :2
+tagger T <t@example.com> 1700000003 +0000
+data 4
+tag
+
+done
+`
+
+func TestFastImport(t *testing.T) {
+	repo, _ := newMemoryRepository(t)
+	result, err := repo.FastImport(strings.NewReader(fastImportStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 4 || result.Commits != 2 || result.Tags != 1 || len(result.Refs) != 3 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	modesOf := func(name string) map[string]uint32 {
+		t.Helper()
+		tree, err := repo.GetTreeOid(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modes, err := repo.getTreeModes(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return modes
+	}
+	want := map[string]uint32{"a": MODE_FILE, "b": MODE_FILE, "tools/run": MODE_EXECUTABLE, "sp ace\tq": MODE_FILE}
+	if modes := modesOf("refs/heads/main"); !reflect.DeepEqual(modes, want) {
+		t.Fatalf("main has %v, want %v", modes, want)
+	}
+	want = map[string]uint32{"a": MODE_FILE, "bin/run": MODE_EXECUTABLE, "link": MODE_SYMLINK, "sp ace\tq": MODE_FILE}
+	if modes := modesOf("refs/heads/other"); !reflect.DeepEqual(modes, want) {
+		t.Fatalf("other has %v, want %v", modes, want)
+	}
+
+	main, err := repo.RevParse("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.GetCommit(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.GetMessage() != "second" || len(commit.GetParents()) != 1 {
+		t.Fatalf("unexpected second commit %v", &commit)
+	}
+	first, err := repo.GetCommit(commit.GetParents()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	author, committer := first.GetAuthor(), first.GetCommitter()
+	if first.GetMessage() != "first\n" || author.GetName() != "A" || author.GetWhen() != 1700000000 ||
+		committer.GetEmail() != "c@example.com" || committer.GetTzOffset() != 90 {
+		t.Fatalf("unexpected first commit %v", &first)
+	}
+	tagged, err := repo.RevParse("v1^{commit}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagged != commit.GetParents()[0] {
+		t.Fatalf("v1 points at %s", tagged)
+	}
+	other, err := repo.RevParse("refs/heads/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != tagged {
+		t.Fatalf("other was reset to %s", other)
+	}
+}
+
+func TestFastImportErrors(t *testing.T) {
+	commit := "commit refs/heads/main\ncommitter C <c@example.com> 1700000000 +0000\ndata 0\n"
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+	}{
+		{"unknown command", "frobnicate\n", "unsupported command"},
+		{"bad data length", "blob\ndata x\n", "bad data length"},
+		{"short data", "blob\ndata 10\nabc\n", "EOF"},
+		{"missing delimiter", "blob\ndata <<END\nabc\n", "missing data delimiter"},
+		{"no data", "blob\nmark :1\n", "expected data"},
+		{"undeclared mark", commit + "M 100644 :7 a\n", "mark :7 not declared"},
+		{"bad mode", commit + "M 100600 inline a\ndata 0\n", "unsupported mode"},
+		{"bad filemodify", commit + "M 100644 a\n", "bad filemodify"},
+		{"rename of a missing path", commit + "R a b\n", "not in branch"},
+		{"unterminated path", commit + "R \"a b\n", "unterminated path"},
+		{"unsupported file command", commit + "N inline a\n", "unsupported command"},
+		{"tag without from", "tag v1\ndata 0\n", "has no from"},
+		{"bad signature", "commit refs/heads/main\ncommitter nobody\ndata 0\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, _ := newMemoryRepository(t)
+			result, err := repo.FastImport(strings.NewReader(test.stream))
+			if err == nil {
+				t.Fatalf("imported %+v", result)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("error %q does not mention %q", err, test.want)
+			}
+			if branches, err := repo.IterBranchNames(); err != nil || len(branches) != 0 {
+				t.Fatalf("branches %v were written (%v)", branches, err)
+			}
+		})
+	}
+}
+
+func TestFastExportRoundTrip(t *testing.T) {
+	repo, _ := newMemoryRepository(t)
+	if _, err := repo.FastImport(strings.NewReader(fastImportStream)); err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	names := []string{"refs/heads/main", "refs/heads/other", "refs/tags/v1"}
+	if err := repo.FastExport(&stream, names); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, _ := newMemoryRepository(t)
+	if _, err := copied.FastImport(bytes.NewReader(stream.Bytes())); err != nil {
+		t.Fatalf("%s\n%s", err, stream.String())
+	}
+	for _, name := range names {
+		want, err := repo.resolveName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := copied.resolveName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%s is %s after the round trip, not %s\n%s", name, got, want, stream.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// FsckResult lists what Fsck found. Problems are damaged or missing objects
+// and broken references; dangling objects are merely unreferenced.
+type FsckResult struct {
+	Checked  int
+	Problems []string
+	Dangling []string
+}
+
+// fsckLink is a reference from one object (or ref) to another.
+type fsckLink struct {
+	from  string
+	oid   string
+	type_ string // what the referrer says it is; "" if it does not say
+}
+
+func (result *FsckResult) problem(format string, args ...interface{}) {
+	result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+}
+
+// fsckObject decodes a tree, commit or tag, and returns what it refers to.
+func (repo *Repository) fsckObject(oid string, type_ string, result *FsckResult) []fsckLink {
+	links := []fsckLink{}
+	switch type_ {
+	case "tree":
+		entries, err := repo.iterTreeEntries(oid)
+		if err != nil {
+			result.problem("broken tree %s: %s", oid, err)
+			return nil
+		}
+		names := map[string]bool{}
+		for i := range entries {
+			entry := &entries[i]
+			name := entry.GetName()
+			if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+				result.problem("tree %s has a bad entry name '%s'", oid, name)
+			} else if isIgnored(name) {
+				result.problem("tree %s has an entry '%s' that would be checked out into a git dir", oid, name)
+			} else if names[name] {
+				result.problem("tree %s has a duplicate entry '%s'", oid, name)
+			}
+			names[name] = true
+			switch entry.GetType_() {
+			case "blob", "tree":
+				links = append(links, fsckLink{from: oid, oid: entry.GetOid(), type_: entry.GetType_()})
+			case "commit": // a submodule, stored elsewhere
+			default:
+				result.problem("tree %s has an entry '%s' of unknown type %s", oid, name, entry.GetType_())
+			}
+		}
+	case "commit":
+		commit, err := repo.GetCommit(oid)
+		if err != nil {
+			result.problem("broken commit %s: %s", oid, err)
+			return nil
+		}
+		if commit.GetTree() == "" {
+			result.problem("commit %s has no tree", oid)
+		} else {
+			links = append(links, fsckLink{from: oid, oid: commit.GetTree(), type_: "tree"})
+		}
+		for _, parent := range commit.GetParents() {
+			links = append(links, fsckLink{from: oid, oid: parent, type_: "commit"})
+		}
+	case "tag":
+		tag, err := repo.GetTag(oid)
+		if err != nil {
+			result.problem("broken tag %s: %s", oid, err)
+			return nil
+		}
+		links = append(links, fsckLink{from: oid, oid: tag.GetObject(), type_: tag.GetType_()})
+	case "blob":
+	default:
+		result.problem("object %s has unknown type %s", oid, type_)
+	}
+	return links
+}
+
+// Fsck rehashes every object, decodes every tree, commit and tag, and checks
+// that whatever they, the refs, the index and the reflogs refer to exists
+// with the right type. Objects nothing refers to are reported as dangling.
+func (repo *Repository) Fsck() (FsckResult, error) {
+	result := FsckResult{}
+	types := map[string]string{}
+	broken := map[string]bool{} // already reported
+	err := repo.Objects.Iterate(func(oid string) error {
+		result.Checked++
+		type_, content, err := repo.readObject(oid)
+		if err != nil {
+			result.problem("cannot read %s: %s", oid, err)
+			broken[oid] = true
+			return nil
+		}
+		actual, err := repo.Objects.Hash(bytes.NewReader(content), type_)
+		if err != nil {
+			return err
+		}
+		if actual != oid {
+			result.problem("hash mismatch for %s %s (content hashes to %s)", type_, oid, actual)
+			broken[oid] = true
+			return nil
+		}
+		types[oid] = type_
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	links := []fsckLink{}
+	oids := make([]string, 0, len(types))
+	for oid := range types {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+	for _, oid := range oids {
+		links = append(links, repo.fsckObject(oid, types[oid], &result)...)
+	}
+
+	roots := []fsckLink{}
+	refs, err := repo.IterRefs("", true)
+	if err != nil {
+		return result, err
+	}
+	for _, ref := range refs {
+		if ref.Value.Value != "" {
+			roots = append(roots, fsckLink{from: ref.Name, oid: ref.Value.Value})
+		}
+	}
+	mergeHead, err := repo.GetRef(data.MERGE_HEAD, false)
+	if err != nil {
+		return result, err
+	}
+	if mergeHead.Value != "" {
+		roots = append(roots, fsckLink{from: data.MERGE_HEAD, oid: mergeHead.Value, type_: "commit"})
+	}
+	if !repo.IsBare() { // which has no index
+		index, err := repo.readIndex()
+		if err != nil {
+			result.problem("cannot read the index: %s", err)
+		}
+		for path, entry := range index {
+			roots = append(roots, fsckLink{from: "index entry " + path, oid: entry.GetOid(), type_: "blob"})
+		}
+	}
+	logged, err := repo.reflogOids()
+	if err != nil {
+		result.problem("cannot read the reflogs: %s", err)
+	}
+	for _, ref := range logged {
+		roots = append(roots, fsckLink{from: "reflog of " + ref.Name, oid: ref.Value.Value, type_: "commit"})
+	}
+	others, othersLogged, err := repo.otherWorktreeRoots()
+	if err != nil {
+		result.problem("cannot read the other worktrees: %s", err)
+	}
+	roots = append(append(roots, others...), othersLogged...)
+
+	referenced := map[string]bool{}
+	children := map[string][]string{}
+	for _, link := range append(links, roots...) {
+		referenced[link.oid] = true
+		children[link.from] = append(children[link.from], link.oid)
+		actual, exists := types[link.oid]
+		if broken[link.oid] {
+			continue
+		} else if !exists {
+			type_ := link.type_
+			if type_ == "" {
+				type_ = "object"
+			}
+			result.problem("missing %s %s (referred to by %s)", type_, link.oid, link.from)
+		} else if link.type_ != "" && link.type_ != actual {
+			result.problem("%s refers to %s as a %s, but it is a %s", link.from, link.oid, link.type_, actual)
+		}
+	}
+
+	reachable := map[string]bool{}
+	stack := []string{}
+	for _, root := range roots {
+		stack = append(stack, root.oid)
+	}
+	for len(stack) > 0 {
+		oid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[oid] {
+			continue
+		}
+		reachable[oid] = true
+		stack = append(stack, children[oid]...)
+	}
+	for _, oid := range oids {
+		if !reachable[oid] && !referenced[oid] {
+			result.Dangling = append(result.Dangling, fmt.Sprintf("%s %s", types[oid], oid))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,186 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"jerroyd.com/ugit/data"
+)
+
+// DEFAULT_PRUNE_EXPIRE is how old an unreachable object must be before gc
+// removes it, so that objects being written for a commit in progress survive.
+const DEFAULT_PRUNE_EXPIRE time.Duration = 14 * 24 * time.Hour
+
+// GCResult summarizes what GC did.
+type GCResult struct {
+	Reachable int
+	Pruned    int
+	Packed    int
+	Deltas    int
+}
+
+// reflogOids lists every oid the reflogs remember, with the ref whose log
+// mentions it.
+func (repo *Repository) reflogOids() ([]data.NamedRef, error) {
+	oids := []data.NamedRef{}
+	refs, err := repo.IterReflogs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		entries, err := repo.ReadReflog(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			for _, oid := range []string{entry.Old, entry.New} {
+				if oid != "" {
+					oids = append(oids, data.NamedRef{Name: ref, Value: data.RefValue{Value: oid}})
+				}
+			}
+		}
+	}
+	return oids, nil
+}
+
+// gcRoots lists the oids that keep objects alive: every ref, MERGE_HEAD,
+// whatever is staged and whatever the reflogs remember, in every worktree.
+func (repo *Repository) gcRoots() ([]string, error) {
+	roots := []string{}
+	refs, err := repo.IterRefs("", true)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		roots = append(roots, ref.Value.Value)
+	}
+	mergeHead, err := repo.GetRef(data.MERGE_HEAD, false)
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, mergeHead.Value)
+	if !repo.IsBare() { // which has no index
+		index, err := repo.readIndex()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range index {
+			roots = append(roots, entry.GetOid())
+		}
+	}
+	logged, err := repo.reflogOids()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range logged {
+		// a log may outlive what it mentions, when it was pruned some other way
+		if repo.Objects.Has(ref.Value.Value) {
+			roots = append(roots, ref.Value.Value)
+		}
+	}
+	others, othersLogged, err := repo.otherWorktreeRoots()
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range others {
+		roots = append(roots, link.oid)
+	}
+	for _, link := range othersLogged {
+		if repo.Objects.Has(link.oid) {
+			roots = append(roots, link.oid)
+		}
+	}
+	return roots, nil
+}
+
+// walkReachable finds every object reachable from roots, as oid -> type.
+func (repo *Repository) walkReachable(roots []string) (map[string]string, error) {
+	reachable := map[string]string{}
+	type pending struct {
+		oid   string
+		type_ string // "" when not known yet
+	}
+	stack := []pending{}
+	for _, root := range roots {
+		if root != "" {
+			stack = append(stack, pending{oid: root})
+		}
+	}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, seen := reachable[next.oid]; seen {
+			continue
+		}
+		type_ := next.type_
+		if type_ == "" {
+			var err error
+			type_, err = repo.getObjectType(next.oid)
+			if err != nil {
+				return nil, err
+			}
+		}
+		reachable[next.oid] = type_
+		switch type_ {
+		case "commit":
+			commit, err := repo.GetCommit(next.oid)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, pending{oid: commit.GetTree(), type_: "tree"})
+			for _, parent := range commit.GetParents() {
+				stack = append(stack, pending{oid: parent, type_: "commit"})
+			}
+		case "tree":
+			entries, err := repo.iterTreeEntries(next.oid)
+			if err != nil {
+				return nil, err
+			}
+			for i := range entries {
+				if entries[i].Type_ != "commit" { // submodules live elsewhere
+					stack = append(stack, pending{oid: entries[i].Oid, type_: entries[i].Type_})
+				}
+			}
+		case "tag":
+			tag, err := repo.GetTag(next.oid)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, pending{oid: tag.GetObject()})
+		case "blob":
+		default:
+			return nil, errors.New(fmt.Sprintf("object %s has unknown type %s", next.oid, type_))
+		}
+	}
+	return reachable, nil
+}
+
+// GC removes loose objects that nothing refers to once they are older than
+// expire, then optionally repacks what is left reachable.
+func (repo *Repository) GC(expire time.Duration, repack bool) (GCResult, error) {
+	if _, ok := repo.Objects.(data.FileStore); !ok {
+		return GCResult{}, errors.New("gc only works on the repository's object directory")
+	}
+	roots, err := repo.gcRoots()
+	if err != nil {
+		return GCResult{}, err
+	}
+	reachable, err := repo.walkReachable(roots)
+	if err != nil {
+		return GCResult{}, errors.New(fmt.Sprintf("cannot collect garbage: %s", err))
+	}
+	keep := func(oid string) bool {
+		_, ok := reachable[oid]
+		return ok
+	}
+	result := GCResult{Reachable: len(reachable)}
+	if repack {
+		result.Packed, result.Deltas, err = repo.Repack(keep)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.Pruned, err = repo.PruneObjects(keep, time.Now().Add(-expire))
+	return result, err
+}
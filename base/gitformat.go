@@ -0,0 +1,264 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"jerroyd.com/ugit/data"
+)
+
+// Encoding of trees, commits and tags in the git object format, for
+// repositories initialized with it. Objects are then interchangeable with
+// git's own:
+//
+//	tree:   "<octal mode> <name>\0<20 byte oid>" for each entry
+//	commit: "tree", "parent", "author" and "committer" headers, a blank
+//	        line and the message
+//	tag:    "object", "type", "tag" and "tagger" headers, a blank line and
+//	        the message
+
+func (repo *Repository) isGitFormat() (bool, error) {
+	format, err := repo.GetObjectFormat()
+	return format == data.OBJECT_FORMAT_GIT, err
+}
+
+// entryMode is the mode of a tree entry, filling in the default for entries
+// written before modes were recorded.
+func entryMode(obj *UgitObject) uint32 {
+	if obj.GetMode() != 0 {
+		return obj.GetMode()
+	} else if obj.GetType_() == "tree" {
+		return MODE_TREE
+	}
+	return MODE_FILE
+}
+
+// gitTreeOrder sorts tree entries the way git does, as if the names of trees
+// ended with a '/'.
+func gitTreeOrder(list []*UgitObject) []*UgitObject {
+	sorted := append([]*UgitObject{}, list...)
+	key := func(obj *UgitObject) string {
+		if obj.GetType_() == "tree" {
+			return obj.GetName() + "/"
+		}
+		return obj.GetName()
+	}
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+	return sorted
+}
+
+func encodeGitTree(list []*UgitObject) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, obj := range gitTreeOrder(list) {
+		oid, err := hex.DecodeString(obj.GetOid())
+		if err != nil || len(oid) != data.OID_LEN/2 {
+			return nil, errors.New(fmt.Sprintf("invalid oid '%s' for %s", obj.GetOid(), obj.GetName()))
+		}
+		fmt.Fprintf(&buf, "%o %s\000", entryMode(obj), obj.GetName())
+		buf.Write(oid)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGitTree(r io.Reader) ([]UgitObject, error) {
+	br := bufio.NewReader(r)
+	objectList := make([]UgitObject, 0)
+	for {
+		header, err := br.ReadString('\000')
+		if err == io.EOF && header == "" {
+			return objectList, nil
+		} else if err != nil {
+			return nil, errors.New("truncated tree entry")
+		}
+		modeStr, name, ok := strings.Cut(strings.TrimSuffix(header, "\000"), " ")
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if !ok || err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid tree entry '%s'", header))
+		}
+		oid := make([]byte, data.OID_LEN/2)
+		if _, err := io.ReadFull(br, oid); err != nil {
+			return nil, errors.New(fmt.Sprintf("truncated tree entry for %s", name))
+		}
+		type_ := "blob"
+		switch uint32(mode) {
+		case MODE_TREE:
+			type_ = "tree"
+		case MODE_GITLINK: // a submodule's commit
+			type_ = "commit"
+		case MODE_FILE, MODE_EXECUTABLE, MODE_SYMLINK, 0100664:
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported mode %o for %s", mode, name))
+		}
+		objectList = append(objectList, UgitObject{
+			Name:  name,
+			Oid:   hex.EncodeToString(oid),
+			Type_: type_,
+			Mode:  uint32(mode),
+		})
+	}
+}
+
+func formatGitSignature(sig *Signature) string {
+	return fmt.Sprintf("%s <%s> %s", sig.GetName(), sig.GetEmail(), data.FormatTimestamp(sig.Time()))
+}
+
+func parseGitSignature(value string) (*Signature, error) {
+	end := strings.LastIndex(value, ">")
+	start := strings.LastIndex(value[:end+1], "<")
+	if start < 0 || end < start {
+		return nil, errors.New(fmt.Sprintf("invalid signature '%s'", value))
+	}
+	when, err := data.ParseTimestamp(value[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	return NewSignature(data.Ident{
+		Name:  strings.TrimSpace(value[:start]),
+		Email: value[start+1 : end],
+		When:  when,
+	}), nil
+}
+
+// writeGitMessage appends the message, which git always terminates with a
+// newline.
+func writeGitMessage(buf *bytes.Buffer, message string) {
+	buf.WriteString("\n")
+	buf.WriteString(message)
+	if !strings.HasSuffix(message, "\n") {
+		buf.WriteString("\n")
+	}
+}
+
+func encodeGitCommit(commit *CommitInfo) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", commit.GetTree())
+	for _, parent := range commit.GetParents() {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	if commit.GetAuthor() != nil {
+		fmt.Fprintf(&buf, "author %s\n", formatGitSignature(commit.GetAuthor()))
+	}
+	if commit.GetCommitter() != nil {
+		fmt.Fprintf(&buf, "committer %s\n", formatGitSignature(commit.GetCommitter()))
+	}
+	writeGitMessage(&buf, commit.GetMessage())
+	return buf.Bytes()
+}
+
+func encodeGitTag(tag *TagInfo) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s\ntype %s\ntag %s\n", tag.GetObject(), tag.GetType_(), tag.GetTag())
+	if tag.GetTagger() != nil {
+		fmt.Fprintf(&buf, "tagger %s\n", formatGitSignature(tag.GetTagger()))
+	}
+	writeGitMessage(&buf, tag.GetMessage())
+	return buf.Bytes()
+}
+
+// parseGitHeaders splits a commit or tag into its headers and message.
+// Headers ugit has no use for, like gpgsig, are skipped along with their
+// continuation lines.
+func parseGitHeaders(r io.Reader) (headers [][2]string, message string, err error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	text := string(buf)
+	for {
+		line, rest, found := strings.Cut(text, "\n")
+		if !found {
+			return nil, "", errors.New("missing blank line after headers")
+		}
+		text = rest
+		if line == "" {
+			break
+		} else if strings.HasPrefix(line, " ") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		headers = append(headers, [2]string{key, value})
+	}
+	return headers, text, nil
+}
+
+func decodeGitCommit(r io.Reader, commit *CommitInfo) error {
+	headers, message, err := parseGitHeaders(r)
+	if err != nil {
+		return err
+	}
+	commit.Message = message
+	for _, header := range headers {
+		switch header[0] {
+		case "tree":
+			commit.Tree = header[1]
+		case "parent":
+			commit.Parents = append(commit.Parents, header[1])
+		case "author":
+			commit.Author, err = parseGitSignature(header[1])
+		case "committer":
+			commit.Committer, err = parseGitSignature(header[1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if commit.Tree == "" {
+		return errors.New("commit has no tree")
+	}
+	return nil
+}
+
+func decodeGitTag(r io.Reader, tag *TagInfo) error {
+	headers, message, err := parseGitHeaders(r)
+	if err != nil {
+		return err
+	}
+	tag.Message = message
+	for _, header := range headers {
+		switch header[0] {
+		case "object":
+			tag.Object = header[1]
+		case "type":
+			tag.Type_ = header[1]
+		case "tag":
+			tag.Tag = header[1]
+		case "tagger":
+			tag.Tagger, err = parseGitSignature(header[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if tag.Object == "" || tag.Type_ == "" {
+		return errors.New("tag has no object")
+	}
+	return nil
+}
+
+func encodeGitMessage(msg proto.Message) ([]byte, error) {
+	switch msg := msg.(type) {
+	case *CommitInfo:
+		return encodeGitCommit(msg), nil
+	case *TagInfo:
+		return encodeGitTag(msg), nil
+	}
+	return nil, errors.New(fmt.Sprintf("no git encoding for %T", msg))
+}
+
+func decodeGitMessage(r io.Reader, msg proto.Message) error {
+	switch msg := msg.(type) {
+	case *CommitInfo:
+		return decodeGitCommit(r, msg)
+	case *TagInfo:
+		return decodeGitTag(r, msg)
+	}
+	return errors.New(fmt.Sprintf("no git encoding for %T", msg))
+}
@@ -0,0 +1,275 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// ImportResult summarizes what ImportGit did.
+type ImportResult struct {
+	Commits int
+	Refs    []data.NamedRef // the refs written, with their ugit oids
+	Skipped []string        // what could not be imported, and why
+}
+
+type gitImporter struct {
+	repo   *Repository
+	source *data.GitRepo
+	git    bool              // whether this repository uses the git object format
+	oids   map[string]string // git oid -> ugit oid
+	result ImportResult
+}
+
+// importObject copies a git object, and everything it refers to, into the
+// repository, returning its ugit oid. In the git object format the oids are
+// the same, and commits and tags are copied verbatim so that signatures and
+// other headers ugit does not know about survive.
+func (imp *gitImporter) importObject(oid string) (string, error) {
+	if mapped, done := imp.oids[oid]; done {
+		return mapped, nil
+	}
+	type_, content, err := imp.source.ReadObject(oid)
+	if err != nil {
+		return "", err
+	}
+	var mapped string
+	switch type_ {
+	case "blob":
+		mapped, err = imp.repo.Objects.Put(bytes.NewReader(content), "blob")
+	case "tree":
+		mapped, err = imp.importTree(oid, content)
+	case "commit":
+		return imp.importCommits(oid)
+	case "tag":
+		mapped, err = imp.importTag(oid, content)
+	default:
+		err = errors.New(fmt.Sprintf("git object %s has unknown type %s", oid, type_))
+	}
+	if err != nil {
+		return "", err
+	}
+	imp.oids[oid] = mapped
+	return mapped, nil
+}
+
+func (imp *gitImporter) importTree(oid string, content []byte) (string, error) {
+	entries, err := decodeGitTree(bytes.NewReader(content))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("tree %s: %s", oid, err))
+	}
+	list := []*UgitObject{}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type_ == "commit" {
+			imp.result.Skipped = append(imp.result.Skipped, fmt.Sprintf("submodule %s in tree %s", entry.Name, oid))
+			continue
+		}
+		if strings.Contains(entry.Name, "/") || checkPath(entry.Name) != nil {
+			// it could not be checked out, or would land in the git dir
+			imp.result.Skipped = append(imp.result.Skipped, fmt.Sprintf("invalid entry '%s' in tree %s", entry.Name, oid))
+			continue
+		}
+		mapped, err := imp.importObject(entry.Oid)
+		if err != nil {
+			return "", err
+		}
+		list = append(list, &UgitObject{Name: entry.Name, Oid: mapped, Type_: entry.Type_, Mode: entry.Mode})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetName() < list[j].GetName() })
+	return imp.repo.writeTreeObject(list)
+}
+
+// importCommits imports a commit after all of its ancestors, without
+// recursing, since histories can be far deeper than the stack.
+func (imp *gitImporter) importCommits(tip string) (string, error) {
+	parsed := map[string]*CommitInfo{}
+	raw := map[string][]byte{}
+	stack := []string{tip}
+	for len(stack) > 0 {
+		oid := stack[len(stack)-1]
+		if _, done := imp.oids[oid]; done {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		commit, ok := parsed[oid]
+		if !ok {
+			type_, content, err := imp.source.ReadObject(oid)
+			if err != nil {
+				return "", err
+			}
+			if type_ != "commit" {
+				return "", errors.New(fmt.Sprintf("git object %s is a %s, not a commit", oid, type_))
+			}
+			commit = &CommitInfo{}
+			if err := decodeGitCommit(bytes.NewReader(content), commit); err != nil {
+				return "", errors.New(fmt.Sprintf("commit %s: %s", oid, err))
+			}
+			parsed[oid], raw[oid] = commit, content
+		}
+		pending := false
+		for _, parent := range commit.GetParents() {
+			if _, done := imp.oids[parent]; !done {
+				stack = append(stack, parent)
+				pending = true
+			}
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+
+		tree, err := imp.importObject(commit.GetTree())
+		if err != nil {
+			return "", err
+		}
+		verbatim := imp.git && tree == commit.GetTree()
+		parents := make([]string, 0, len(commit.GetParents()))
+		for _, parent := range commit.GetParents() {
+			parents = append(parents, imp.oids[parent])
+			verbatim = verbatim && imp.oids[parent] == parent
+		}
+		var mapped string
+		if verbatim {
+			mapped, err = imp.repo.Objects.Put(bytes.NewReader(raw[oid]), "commit")
+		} else {
+			mapped, err = imp.repo.hashMessage(&CommitInfo{
+				Message:   commit.GetMessage(),
+				Tree:      tree,
+				Parents:   parents,
+				Author:    commit.GetAuthor(),
+				Committer: commit.GetCommitter(),
+			}, "commit")
+		}
+		if err != nil {
+			return "", err
+		}
+		imp.oids[oid] = mapped
+		delete(parsed, oid)
+		delete(raw, oid)
+		imp.result.Commits++
+	}
+	return imp.oids[tip], nil
+}
+
+func (imp *gitImporter) importTag(oid string, content []byte) (string, error) {
+	tag := TagInfo{}
+	if err := decodeGitTag(bytes.NewReader(content), &tag); err != nil {
+		return "", errors.New(fmt.Sprintf("tag %s: %s", oid, err))
+	}
+	object, err := imp.importObject(tag.GetObject())
+	if err != nil {
+		return "", err
+	}
+	if imp.git && object == tag.GetObject() {
+		return imp.repo.Objects.Put(bytes.NewReader(content), "tag")
+	}
+	return imp.repo.hashMessage(&TagInfo{
+		Object:  object,
+		Type_:   tag.GetType_(),
+		Tag:     tag.GetTag(),
+		Tagger:  tag.GetTagger(),
+		Message: tag.GetMessage(),
+	}, "tag")
+}
+
+// ImportGit copies the branches and tags of the git repository at path,
+// along with their history, into this repository. The checked out branch is
+// left alone if it already has commits; in a repository without any, HEAD
+// follows the git repository's current branch, which is checked out.
+func (repo *Repository) ImportGit(path string) (ImportResult, error) {
+	source, err := data.OpenGitRepo(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	git, err := repo.isGitFormat()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	imp := &gitImporter{repo: repo, source: source, git: git, oids: map[string]string{}}
+	refs, err := source.IterRefs()
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	head, err := repo.GetRef(data.HEAD, false)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	headOid, err := repo.GetHead()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	for _, ref := range refs {
+		name := strings.TrimPrefix(strings.TrimPrefix(ref.Name, data.HEADS_PREFIX), data.TAGS_PREFIX)
+		if err := data.CheckRefName(name); err != nil {
+			imp.result.Skipped = append(imp.result.Skipped, fmt.Sprintf("%s: %s", ref.Name, err))
+			continue
+		}
+		oid, err := imp.importObject(ref.Value.Value)
+		if err != nil {
+			return imp.result, errors.New(fmt.Sprintf("%s: %s", ref.Name, err))
+		}
+		if head.Symbolic && head.Value == ref.Name && headOid != "" && headOid != oid {
+			imp.result.Skipped = append(imp.result.Skipped, fmt.Sprintf("%s: checked out here", ref.Name))
+			continue
+		}
+		err = repo.UpdateRef(ref.Name, data.RefValue{Value: oid}, false, "import-git: "+source.Dir)
+		if err != nil {
+			return imp.result, err
+		}
+		imp.result.Refs = append(imp.result.Refs, data.NamedRef{Name: ref.Name, Value: data.RefValue{Value: oid}})
+	}
+
+	if headOid == "" {
+		gitHead, err := source.Head()
+		if err == nil && gitHead.Symbolic && repo.RefExists(gitHead.Value) {
+			err = repo.UpdateRef(data.HEAD, gitHead, false, "import-git: "+source.Dir)
+			if err != nil {
+				return imp.result, err
+			}
+		}
+		if !repo.IsBare() {
+			if err := repo.checkoutUnbornHead(); err != nil {
+				return imp.result, errors.New(fmt.Sprintf("imported, but HEAD cannot be checked out: %s", err))
+			}
+		}
+	}
+	return imp.result, nil
+}
+
+// checkoutUnbornHead writes out the commit HEAD points at, when it came to
+// point at one without a checkout, because refs were imported while it was
+// unborn. Untracked files in the way are left alone, and an error.
+func (repo *Repository) checkoutUnbornHead() error {
+	head, err := repo.GetHead()
+	if err != nil || head == "" {
+		return err
+	}
+	tree, err := repo.getCommitTree(head)
+	if err != nil {
+		return err
+	}
+	target, err := repo.getTreeMap(tree)
+	if err != nil {
+		return err
+	}
+	modes, err := repo.getTreeModes(tree)
+	if err != nil {
+		return err
+	}
+	lock, index, err := repo.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	err = repo.updateWorkingTree(map[string]string{}, target, modes, index)
+	if err != nil {
+		return err
+	}
+	return repo.commitIndex(lock, index)
+}
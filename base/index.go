@@ -0,0 +1,365 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"jerroyd.com/ugit/data"
+)
+
+const MODE_FILE uint32 = 0100644
+const MODE_EXECUTABLE uint32 = 0100755
+const MODE_TREE uint32 = 040000
+const MODE_SYMLINK uint32 = 0120000
+const MODE_GITLINK uint32 = 0160000
+
+// cleanPath turns a command line path into the slash separated form used by
+// the index. The repository root is "".
+func cleanPath(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." {
+		return ""
+	}
+	return path
+}
+
+// isUnder reports whether path is prefix itself or lives inside it.
+func isUnder(path string, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// infoMode is the mode a file in the worktree is staged with.
+func infoMode(info fs.FileInfo) uint32 {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return MODE_SYMLINK
+	} else if info.Mode()&0111 != 0 {
+		return MODE_EXECUTABLE
+	}
+	return MODE_FILE
+}
+
+// workingMode is the mode of the file staged as entry as it now is in the
+// worktree. A symlink that had to be checked out as a plain file keeps its
+// mode.
+func workingMode(entry *IndexEntry, info fs.FileInfo) uint32 {
+	if entry.GetMode() == MODE_SYMLINK && info.Mode().IsRegular() {
+		return MODE_SYMLINK
+	}
+	return infoMode(info)
+}
+
+// indexMode is the mode of entry, filling in the default for entries
+// written before modes were recorded.
+func indexMode(entry *IndexEntry) uint32 {
+	if entry.GetMode() == 0 {
+		return MODE_FILE
+	}
+	return entry.GetMode()
+}
+
+func newIndexEntry(path string, oid string, info fs.FileInfo) *IndexEntry {
+	entry := &IndexEntry{
+		Path: path,
+		Oid:  oid,
+		Mode: MODE_FILE,
+	}
+	if info != nil {
+		entry.Mode = infoMode(info)
+		entry.Size = info.Size()
+		entry.Mtime = info.ModTime().UnixNano()
+	}
+	return entry
+}
+
+// checkedOutEntry is the index entry of a file just checked out. It keeps
+// the mode of the tree even where the worktree could not represent it.
+func (repo *Repository) checkedOutEntry(path string, oid string, mode uint32) (*IndexEntry, error) {
+	info, err := os.Lstat(repo.workPath(path))
+	if err != nil {
+		return nil, err
+	}
+	entry := newIndexEntry(path, oid, info)
+	entry.Mode = mode
+	return entry, nil
+}
+
+// isWorkingFile says whether info is of something that can be staged: a
+// regular file or a symlink.
+func isWorkingFile(info fs.FileInfo) bool {
+	return info.Mode().IsRegular() || info.Mode()&fs.ModeSymlink != 0
+}
+
+// indexFromTree builds index entries for every blob in a tree. They carry no
+// stat information, so the working copy is rehashed the first time it is
+// compared against them.
+func (repo *Repository) indexFromTree(treeOid string) (map[string]*IndexEntry, error) {
+	treeMap, err := repo.getTreeMap(treeOid)
+	if err != nil {
+		return nil, err
+	}
+	modes, err := repo.getTreeModes(treeOid)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]*IndexEntry{}
+	for path, oid := range treeMap {
+		entries[path] = newIndexEntry(path, oid, nil)
+		entries[path].Mode = modes[path]
+	}
+	return entries, nil
+}
+
+// readIndex loads the staging area. Without an index file, the tree of HEAD
+// is what is staged.
+func (repo *Repository) readIndex() (map[string]*IndexEntry, error) {
+	buf, err := repo.ReadIndex()
+	if errors.Is(err, os.ErrNotExist) {
+		head, err := repo.GetHead()
+		if err != nil {
+			return nil, err
+		}
+		tree, err := repo.getCommitTree(head)
+		if err != nil {
+			return nil, err
+		}
+		return repo.indexFromTree(tree)
+	} else if err != nil {
+		return nil, err
+	}
+	index := Index{}
+	err = proto.Unmarshal(buf, &index)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]*IndexEntry{}
+	for _, entry := range index.GetEntries() {
+		entries[entry.GetPath()] = entry
+	}
+	return entries, nil
+}
+
+func sortedIndexEntries(entries map[string]*IndexEntry) []*IndexEntry {
+	list := make([]*IndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetPath() < list[j].GetPath() })
+	return list
+}
+
+// lockIndex takes the lock on the index before reading it, so that nobody
+// can change it until commitIndex writes the new one. The lock must be
+// rolled back if that does not happen.
+func (repo *Repository) lockIndex() (*data.IndexLock, map[string]*IndexEntry, error) {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := repo.readIndex()
+	if err != nil {
+		lock.Rollback()
+		return nil, nil, err
+	}
+	return lock, entries, nil
+}
+
+// commitIndex writes entries as the index and releases its lock.
+func (repo *Repository) commitIndex(lock *data.IndexLock, entries map[string]*IndexEntry) error {
+	index := Index{Entries: sortedIndexEntries(entries)}
+	buf, err := proto.Marshal(&index)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit(buf)
+}
+
+// stageFile stores the file at path and stages it. A symlink is stored as
+// the path it points to.
+func (repo *Repository) stageFile(entries map[string]*IndexEntry, path string) error {
+	info, err := os.Lstat(repo.workPath(path))
+	if err != nil {
+		return err
+	}
+	var content io.Reader
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(repo.workPath(path))
+		if err != nil {
+			return err
+		}
+		content = strings.NewReader(filepath.ToSlash(target))
+	} else {
+		fh, err := os.Open(repo.workPath(path))
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		content = fh
+	}
+	oid, err := repo.Objects.Put(content, "blob")
+	if err != nil {
+		return err
+	}
+	entries[path] = newIndexEntry(path, oid, info)
+	return nil
+}
+
+// Add stages the current content of files, given relative to the worktree.
+// Directories are added recursively, and files missing from the working
+// directory are unstaged.
+func (repo *Repository) Add(paths []string) error {
+	lock, entries, err := repo.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	for _, arg := range paths {
+		path := cleanPath(arg)
+		if isIgnored(path) {
+			continue
+		}
+		matched := false
+		for indexed := range entries {
+			if isUnder(indexed, path) {
+				matched = true
+				if _, exists, err := repo.hashWorkingFile(indexed); err == nil && !exists {
+					delete(entries, indexed)
+				}
+			}
+		}
+		info, err := os.Lstat(repo.workPath(path))
+		if errors.Is(err, os.ErrNotExist) {
+			if !matched {
+				return errors.New(fmt.Sprintf("pathspec '%s' did not match any files", arg))
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			err = repo.stageFile(entries, path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.WalkDir(repo.workPath(path), func(full string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			full, err = filepath.Rel(repo.workPath(""), full)
+			if err != nil {
+				return err
+			}
+			if isIgnored(full) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() && entry.Type()&fs.ModeSymlink == 0 {
+				return nil
+			}
+			return repo.stageFile(entries, cleanPath(full))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := repo.commitIndex(lock, entries); err != nil {
+		return err
+	}
+	return repo.resolveConflicts(paths)
+}
+
+// Remove unstages files, deleting them from the working directory as well
+// unless cached is set. Files whose working copy differs from the index are
+// only removed when forced.
+func (repo *Repository) Remove(paths []string, cached bool, force bool) error {
+	lock, entries, err := repo.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	removed := []string{}
+	for _, arg := range paths {
+		path := cleanPath(arg)
+		matched := false
+		for indexed, entry := range entries {
+			if !isUnder(indexed, path) {
+				continue
+			}
+			matched = true
+			if !cached && !force {
+				oid, exists, err := repo.hashWorkingFile(indexed)
+				if err != nil {
+					return err
+				}
+				if exists && oid != entry.GetOid() {
+					return errors.New(fmt.Sprintf("'%s' has local modifications", indexed))
+				}
+			}
+			removed = append(removed, indexed)
+		}
+		if !matched {
+			return errors.New(fmt.Sprintf("pathspec '%s' did not match any files", arg))
+		}
+	}
+	for _, path := range removed {
+		delete(entries, path)
+		if !cached {
+			err = repo.removeWorkingFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := repo.commitIndex(lock, entries); err != nil {
+		return err
+	}
+	return repo.resolveConflicts(paths)
+}
+
+// WriteTreeFromIndex stores the staged files as a hierarchy of tree objects
+// and returns the oid of the root tree.
+func (repo *Repository) WriteTreeFromIndex() (oid string, err error) {
+	entries, err := repo.readIndex()
+	if err != nil {
+		return "", err
+	}
+	return repo.writeIndexTree(sortedIndexEntries(entries), "")
+}
+
+func (repo *Repository) writeIndexTree(entries []*IndexEntry, prefix string) (oid string, err error) {
+	list := []*UgitObject{}
+	subdirs := []string{}
+	children := map[string][]*IndexEntry{}
+	for _, entry := range entries {
+		rest := strings.TrimPrefix(entry.GetPath(), prefix)
+		name, _, nested := strings.Cut(rest, "/")
+		if !nested {
+			list = append(list, &UgitObject{Name: name, Oid: entry.GetOid(), Type_: "blob", Mode: entry.GetMode()})
+			continue
+		}
+		if _, ok := children[name]; !ok {
+			subdirs = append(subdirs, name)
+		}
+		children[name] = append(children[name], entry)
+	}
+	for _, name := range subdirs {
+		oid, err := repo.writeIndexTree(children[name], prefix+name+"/")
+		if err != nil {
+			return "", err
+		}
+		list = append(list, &UgitObject{Name: name, Oid: oid, Type_: "tree", Mode: MODE_TREE})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetName() < list[j].GetName() })
+	return repo.writeTreeObject(list)
+}
@@ -0,0 +1,91 @@
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"jerroyd.com/ugit/data"
+)
+
+// newMemoryRepository initializes a repository in a temporary directory
+// whose objects are only kept in memory.
+func newMemoryRepository(t *testing.T) (*Repository, *data.MemoryStore) {
+	store := data.NewMemoryStore()
+	repo := &Repository{Repository: data.NewRepository(t.TempDir()), Objects: store}
+	if err := repo.Initialize(""); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"user.name": "Test", "user.email": "test@example.com"} {
+		if err := repo.SetConfig(key, value, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, store
+}
+
+func writeWorkingFile(t *testing.T, repo *Repository, path string, content string) {
+	if err := os.WriteFile(repo.workPath(path), []byte(content), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStoreWorkflow(t *testing.T) {
+	repo, store := newMemoryRepository(t)
+
+	writeWorkingFile(t, repo, "a", "one\n")
+	if err := repo.Add([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := repo.Commit("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has(first) {
+		t.Fatalf("commit %s is not in the memory store", first)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(repo.GitDir, "objects")); len(entries) != 0 {
+		t.Fatalf("objects were written to disk: %v", entries)
+	}
+
+	status, err := repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Staged.IsEmpty() || !status.Unstaged.IsEmpty() || len(status.Untracked) != 0 {
+		t.Fatalf("status is not clean after the commit: %+v", status)
+	}
+
+	writeWorkingFile(t, repo, "a", "two\n")
+	status, err = repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Unstaged.Modified) != 1 || status.Unstaged.Modified[0] != "a" {
+		t.Fatalf("the change to a is not seen: %+v", status)
+	}
+	if err := repo.Add([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit("second"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Checkout(first); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(repo.workPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "one\n" {
+		t.Fatalf("checkout left a with %q", buf)
+	}
+	status, err = repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Head != first || !status.Staged.IsEmpty() || !status.Unstaged.IsEmpty() {
+		t.Fatalf("status is not clean after the checkout: %+v", status)
+	}
+}
@@ -0,0 +1,498 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"jerroyd.com/ugit/data"
+)
+
+const CONFLICT_OURS string = "<<<<<<<"
+const CONFLICT_SEPARATOR string = "======="
+const CONFLICT_THEIRS string = ">>>>>>>"
+
+type MergeResult struct {
+	UpToDate    bool
+	FastForward bool
+	Conflicts   []string
+}
+
+// IterCommitsAndParents walks the history reachable from oids, visiting every
+// commit once. First parents are followed before the others.
+func (repo *Repository) IterCommitsAndParents(oids []string) ([]string, error) {
+	queue := append([]string{}, oids...)
+	visited := map[string]bool{}
+	commits := []string{}
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if oid == "" || visited[oid] {
+			continue
+		}
+		visited[oid] = true
+		commits = append(commits, oid)
+
+		commit, err := repo.GetCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		parents := commit.GetParents()
+		if len(parents) > 0 {
+			queue = append([]string{parents[0]}, queue...)
+			queue = append(queue, parents[1:]...)
+		}
+	}
+	return commits, nil
+}
+
+// GetMergeBase returns the best common ancestor of two commits: one that is
+// not itself an ancestor of another common ancestor. It returns "" when the
+// histories are unrelated.
+func (repo *Repository) GetMergeBase(a string, b string) (string, error) {
+	ancestorsOfA, err := repo.IterCommitsAndParents([]string{a})
+	if err != nil {
+		return "", err
+	}
+	inA := map[string]bool{}
+	for _, oid := range ancestorsOfA {
+		inA[oid] = true
+	}
+	ancestorsOfB, err := repo.IterCommitsAndParents([]string{b})
+	if err != nil {
+		return "", err
+	}
+	common := []string{}
+	parents := []string{}
+	for _, oid := range ancestorsOfB {
+		if !inA[oid] {
+			continue
+		}
+		common = append(common, oid)
+		commit, err := repo.GetCommit(oid)
+		if err != nil {
+			return "", err
+		}
+		parents = append(parents, commit.GetParents()...)
+	}
+	// anything reachable from a common ancestor's parents is redundant
+	redundant, err := repo.IterCommitsAndParents(parents)
+	if err != nil {
+		return "", err
+	}
+	isRedundant := map[string]bool{}
+	for _, oid := range redundant {
+		isRedundant[oid] = true
+	}
+	for _, oid := range common {
+		if !isRedundant[oid] {
+			return oid, nil
+		}
+	}
+	return "", nil
+}
+
+type mergeChunk struct {
+	conflict bool
+	base     []string
+	ours     []string
+	theirs   []string
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchingLines maps 1-based line numbers of a onto the lines of b they are
+// left unchanged as.
+func matchingLines(a []string, b []string) map[int]int {
+	matches := map[int]int{}
+	for _, edit := range myersDiff(a, b) {
+		if edit.op == opEqual {
+			matches[edit.aIdx+1] = edit.bIdx + 1
+		}
+	}
+	return matches
+}
+
+// merge3 splits a three way merge into stable and unstable chunks, after
+// Khanna, Kunal and Pierce's "A Formal Investigation of Diff3".
+func merge3(base []string, ours []string, theirs []string) []mergeChunk {
+	matchOurs := matchingLines(base, ours)
+	matchTheirs := matchingLines(base, theirs)
+	oLine, aLine, bLine := 0, 0, 0
+	chunks := []mergeChunk{}
+
+	emit := func(o int, a int, b int) {
+		o0, a0, b0 := base[oLine:o], ours[aLine:a], theirs[bLine:b]
+		chunk := mergeChunk{base: o0, ours: a0, theirs: b0}
+		if equalLines(a0, o0) || equalLines(a0, b0) {
+			chunk.ours = b0
+		} else if !equalLines(b0, o0) {
+			chunk.conflict = true
+		}
+		if chunk.conflict || len(chunk.ours) > 0 {
+			chunks = append(chunks, chunk)
+		}
+		oLine, aLine, bLine = o, a, b
+	}
+	inBounds := func(i int) bool {
+		return oLine+i <= len(base) || aLine+i <= len(ours) || bLine+i <= len(theirs)
+	}
+
+	for {
+		// length of the run where all three sides still agree
+		i := 1
+		for inBounds(i) && matchOurs[oLine+i] == aLine+i && matchTheirs[oLine+i] == bLine+i {
+			i++
+		}
+		if !inBounds(i) {
+			break
+		}
+		if i > 1 {
+			emit(oLine+i-1, aLine+i-1, bLine+i-1)
+			continue
+		}
+		// find the next base line both sides kept, closing the unstable chunk
+		o := oLine + 1
+		for o <= len(base) {
+			_, okA := matchOurs[o]
+			_, okB := matchTheirs[o]
+			if okA && okB {
+				break
+			}
+			o++
+		}
+		if o > len(base) {
+			break
+		}
+		emit(o-1, matchOurs[o]-1, matchTheirs[o]-1)
+	}
+	emit(len(base), len(ours), len(theirs))
+	return chunks
+}
+
+// mergeContent merges two descendants of base line by line, returning the
+// result and whether it contains conflict markers.
+func mergeContent(base []byte, ours []byte, theirs []byte, oursName string, theirsName string) ([]byte, bool) {
+	var out bytes.Buffer
+	conflicted := false
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+		if len(lines) > 0 && lines[len(lines)-1][len(lines[len(lines)-1])-1] != '\n' {
+			out.WriteString("\n")
+		}
+	}
+	for _, chunk := range merge3(splitLines(base), splitLines(ours), splitLines(theirs)) {
+		if !chunk.conflict {
+			for _, line := range chunk.ours {
+				out.WriteString(line)
+			}
+			continue
+		}
+		conflicted = true
+		if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "%s %s\n", CONFLICT_OURS, oursName)
+		writeLines(chunk.ours)
+		fmt.Fprintf(&out, "%s\n", CONFLICT_SEPARATOR)
+		writeLines(chunk.theirs)
+		fmt.Fprintf(&out, "%s %s\n", CONFLICT_THEIRS, theirsName)
+	}
+	return out.Bytes(), conflicted
+}
+
+// mergeTrees computes the merged tree of ours and theirs relative to base.
+// Conflicting paths are left out of the merged map, and their content (with
+// conflict markers where possible) is returned separately.
+func (repo *Repository) mergeTrees(base map[string]string, ours map[string]string, theirs map[string]string, theirsName string) (map[string]string, map[string][]byte, error) {
+	paths := map[string]bool{}
+	for _, tree := range []map[string]string{base, ours, theirs} {
+		for path := range tree {
+			paths[path] = true
+		}
+	}
+	merged := map[string]string{}
+	conflicts := map[string][]byte{}
+	for path := range paths {
+		b, inBase := base[path]
+		o, inOurs := ours[path]
+		t, inTheirs := theirs[path]
+		var result string
+		switch {
+		case o == t && inOurs == inTheirs:
+			result = o
+		case b == o && inBase == inOurs:
+			result = t
+		case b == t && inBase == inTheirs:
+			result = o
+		case !inOurs || !inTheirs:
+			// modified on one side, deleted on the other: keep what remains
+			remaining := o
+			if !inOurs {
+				remaining = t
+			}
+			content, err := repo.readBlob(remaining)
+			if err != nil {
+				return nil, nil, err
+			}
+			conflicts[path] = content
+			continue
+		default:
+			baseContent := []byte{}
+			var err error
+			if inBase {
+				if baseContent, err = repo.readBlob(b); err != nil {
+					return nil, nil, err
+				}
+			}
+			oursContent, err := repo.readBlob(o)
+			if err != nil {
+				return nil, nil, err
+			}
+			theirsContent, err := repo.readBlob(t)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isBinary(baseContent) || isBinary(oursContent) || isBinary(theirsContent) {
+				conflicts[path] = oursContent
+				continue
+			}
+			content, conflicted := mergeContent(baseContent, oursContent, theirsContent, "HEAD", theirsName)
+			if conflicted {
+				conflicts[path] = content
+				continue
+			}
+			result, err = repo.Objects.Put(bytes.NewReader(content), "blob")
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if result != "" {
+			merged[path] = result
+		}
+	}
+	return merged, conflicts, nil
+}
+
+// mergeMode merges the mode of path three ways, like its content: a side
+// that changed it wins, and both changing it differently is a conflict, in
+// which case ours is returned.
+func mergeMode(path string, base map[string]uint32, ours map[string]uint32, theirs map[string]uint32) (mode uint32, conflicted bool) {
+	b, inBase := base[path]
+	o, inOurs := ours[path]
+	t, inTheirs := theirs[path]
+	switch {
+	case !inOurs:
+		return t, false
+	case !inTheirs || o == t:
+		return o, false
+	case inBase && b == o:
+		return t, false
+	case inBase && b == t:
+		return o, false
+	}
+	return o, true
+}
+
+// resolveConflicts forgets the conflicts at or under paths, once they have
+// been added or removed.
+func (repo *Repository) resolveConflicts(paths []string) error {
+	conflicts, err := repo.ReadMergeConflicts()
+	if err != nil || len(conflicts) == 0 {
+		return err
+	}
+	remaining := []string{}
+	for _, conflict := range conflicts {
+		resolved := false
+		for _, path := range paths {
+			if isUnder(conflict, cleanPath(path)) {
+				resolved = true
+			}
+		}
+		if !resolved {
+			remaining = append(remaining, conflict)
+		}
+	}
+	return repo.WriteMergeConflicts(remaining)
+}
+
+// Merge joins the history of other (a branch or commit) into HEAD. When HEAD
+// is an ancestor of other, it is fast-forwarded. Otherwise the merge is done
+// in the working directory and index, MERGE_HEAD is recorded, and the next
+// commit gets both parents.
+func (repo *Repository) Merge(other string) (MergeResult, error) {
+	result := MergeResult{}
+	if err := repo.CheckWorkTree(); err != nil {
+		return result, err
+	}
+	name := other
+	other, err := repo.GetCommitOid(other)
+	if err != nil {
+		return result, err
+	}
+	if repo.RefExists(data.MERGE_HEAD) {
+		return result, errors.New("a merge is already in progress; commit it first")
+	}
+	status, err := repo.GetStatus()
+	if err != nil {
+		return result, err
+	}
+	if !status.Staged.IsEmpty() || !status.Unstaged.IsEmpty() {
+		return result, errors.New("your local changes would be overwritten by merge; commit them first")
+	}
+	head := status.Head
+
+	mergeBase := ""
+	if head != "" {
+		mergeBase, err = repo.GetMergeBase(head, other)
+		if err != nil {
+			return result, err
+		}
+	}
+	if head != "" && mergeBase == "" {
+		return result, errors.New("refusing to merge unrelated histories")
+	}
+	if mergeBase == other {
+		result.UpToDate = true
+		return result, nil
+	}
+
+	headTree, err := repo.getCommitTree(head)
+	if err != nil {
+		return result, err
+	}
+	ours, err := repo.getTreeMap(headTree)
+	if err != nil {
+		return result, err
+	}
+	otherTree, err := repo.getCommitTree(other)
+	if err != nil {
+		return result, err
+	}
+	theirs, err := repo.getTreeMap(otherTree)
+	if err != nil {
+		return result, err
+	}
+	oursModes, err := repo.getTreeModes(headTree)
+	if err != nil {
+		return result, err
+	}
+	theirsModes, err := repo.getTreeModes(otherTree)
+	if err != nil {
+		return result, err
+	}
+	lock, index, err := repo.lockIndex()
+	if err != nil {
+		return result, err
+	}
+	defer lock.Rollback()
+
+	if mergeBase == head {
+		result.FastForward = true
+		if err := repo.updateWorkingTree(ours, theirs, theirsModes, index); err != nil {
+			return result, err
+		}
+		if err := repo.commitIndex(lock, index); err != nil {
+			return result, err
+		}
+		return result, repo.CompareAndSwapHead(head, other, "merge "+name+": Fast-forward")
+	}
+
+	baseTree, err := repo.getCommitTree(mergeBase)
+	if err != nil {
+		return result, err
+	}
+	baseMap, err := repo.getTreeMap(baseTree)
+	if err != nil {
+		return result, err
+	}
+	baseModes, err := repo.getTreeModes(baseTree)
+	if err != nil {
+		return result, err
+	}
+	merged, conflicts, err := repo.mergeTrees(baseMap, ours, theirs, name)
+	if err != nil {
+		return result, err
+	}
+	mergedModes := map[string]uint32{}
+	for path, oid := range merged {
+		mode, conflicted := mergeMode(path, baseModes, oursModes, theirsModes)
+		if conflicted {
+			// both sides changed the mode: the merged content is left to be
+			// added with the mode wanted
+			content, err := repo.readBlob(oid)
+			if err != nil {
+				return result, err
+			}
+			conflicts[path] = content
+			delete(merged, path)
+			continue
+		}
+		mergedModes[path] = mode
+	}
+	// conflicting paths keep our version in the index (or theirs, when we
+	// deleted it) until they are resolved and added
+	target := map[string]string{}
+	for path, oid := range merged {
+		target[path] = oid
+	}
+	for path := range conflicts {
+		if oid, ok := ours[path]; ok {
+			target[path] = oid
+		} else if oid, ok := theirs[path]; ok {
+			target[path] = oid
+		}
+	}
+	// a merged file takes the merged mode, and a conflicting one the mode of
+	// the side whose content it keeps
+	modes := map[string]uint32{}
+	for path, oid := range target {
+		if mode, ok := mergedModes[path]; ok {
+			modes[path] = mode
+		} else if mode, ok := oursModes[path]; ok && ours[path] == oid {
+			modes[path] = mode
+		} else if mode, ok := theirsModes[path]; ok && theirs[path] == oid {
+			modes[path] = mode
+		} else if mode, ok := oursModes[path]; ok {
+			modes[path] = mode
+		} else {
+			modes[path] = MODE_FILE
+		}
+	}
+	if err := repo.updateWorkingTree(ours, target, modes, index); err != nil {
+		return result, err
+	}
+	for path, content := range conflicts {
+		full := repo.workPath(path)
+		if err := os.MkdirAll(filepath.Dir(full), os.FileMode(0755)); err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(full, content, 0644); err != nil {
+			return result, err
+		}
+		result.Conflicts = append(result.Conflicts, path)
+	}
+	sort.Strings(result.Conflicts)
+	if err := repo.commitIndex(lock, index); err != nil {
+		return result, err
+	}
+	if err := repo.WriteMergeConflicts(result.Conflicts); err != nil {
+		return result, err
+	}
+	return result, repo.UpdateRef(data.MERGE_HEAD, data.RefValue{Value: other}, false, "merge "+name)
+}
@@ -0,0 +1,243 @@
+package base
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMergeContent(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflicted         bool
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", false},
+		{"ours only", "a\nb\n", "a\nB\n", "a\nb\n", "a\nB\n", false},
+		{"theirs only", "a\nb\n", "a\nb\n", "A\nb\n", "A\nb\n", false},
+		{"same change", "a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n", false},
+		{"separate changes", "a\nb\nc\nd\n", "A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", false},
+		{"both append", "a\n", "a\nb\n", "a\nc\n", "a\n<<<<<<< HEAD\nb\n=======\nc\n>>>>>>> other\n", true},
+		{"both change a line", "a\nb\nc\n", "a\nx\nc\n", "a\ny\nc\n", "a\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> other\nc\n", true},
+		{"delete against edit", "a\nb\nc\n", "a\nc\n", "a\nB\nc\n", "a\n<<<<<<< HEAD\n=======\nB\n>>>>>>> other\nc\n", true},
+		{"no newline at the end", "a", "a\nb", "a", "a\nb", false},
+		{"conflict without newlines", "", "x", "y", "<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> other\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, conflicted := mergeContent([]byte(test.base), []byte(test.ours), []byte(test.theirs), "HEAD", "other")
+			if string(content) != test.want || conflicted != test.conflicted {
+				t.Fatalf("got %q (conflicted %v), want %q (conflicted %v)", content, conflicted, test.want, test.conflicted)
+			}
+		})
+	}
+}
+
+func TestMergeMode(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs uint32 // 0 when missing
+		want               uint32
+		conflicted         bool
+	}{
+		{"unchanged", MODE_FILE, MODE_FILE, MODE_FILE, MODE_FILE, false},
+		{"ours only", MODE_FILE, MODE_EXECUTABLE, MODE_FILE, MODE_EXECUTABLE, false},
+		{"theirs only", MODE_FILE, MODE_FILE, MODE_EXECUTABLE, MODE_EXECUTABLE, false},
+		{"same change", MODE_FILE, MODE_EXECUTABLE, MODE_EXECUTABLE, MODE_EXECUTABLE, false},
+		{"different changes", MODE_FILE, MODE_EXECUTABLE, MODE_SYMLINK, MODE_EXECUTABLE, true},
+		{"added differently", 0, MODE_FILE, MODE_EXECUTABLE, MODE_FILE, true},
+		{"deleted by us", MODE_FILE, 0, MODE_EXECUTABLE, MODE_EXECUTABLE, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sides := []map[string]uint32{{}, {}, {}}
+			for i, mode := range []uint32{test.base, test.ours, test.theirs} {
+				if mode != 0 {
+					sides[i]["f"] = mode
+				}
+			}
+			mode, conflicted := mergeMode("f", sides[0], sides[1], sides[2])
+			if mode != test.want || conflicted != test.conflicted {
+				t.Fatalf("got %o (conflicted %v), want %o (conflicted %v)", mode, conflicted, test.want, test.conflicted)
+			}
+		})
+	}
+}
+
+// commitFiles writes files (removing those set to ""), adds them and
+// commits.
+func commitFiles(t *testing.T, repo *Repository, message string, files map[string]string) string {
+	t.Helper()
+	paths := []string{}
+	for path, content := range files {
+		if content == "" {
+			if err := os.Remove(repo.workPath(path)); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writeWorkingFile(t, repo, path, content)
+		}
+		paths = append(paths, path)
+	}
+	if err := repo.Add(paths); err != nil {
+		t.Fatal(err)
+	}
+	oid, err := repo.Commit(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oid
+}
+
+func readWorkingFile(t *testing.T, repo *Repository, path string) string {
+	t.Helper()
+	buf, err := os.ReadFile(repo.workPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+// newMergeRepository commits base on main, then ours on main and theirs on
+// a branch named other, and returns with main checked out.
+func newMergeRepository(t *testing.T, base map[string]string, ours map[string]string, theirs map[string]string) *Repository {
+	t.Helper()
+	repo, _ := newMemoryRepository(t)
+	oid := commitFiles(t, repo, "base", base)
+	if err := repo.CreateBranch("other", oid); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Checkout("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, "theirs", theirs)
+	if err := repo.Checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, "ours", ours)
+	return repo
+}
+
+func TestMerge(t *testing.T) {
+	repo := newMergeRepository(t,
+		map[string]string{"a": "a\nb\nc\n", "b": "b\n", "c": "c\n"},
+		map[string]string{"a": "A\nb\nc\n", "c": ""},
+		map[string]string{"a": "a\nb\nC\n", "d": "d\n"})
+	result, err := repo.Merge("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FastForward || len(result.Conflicts) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if content := readWorkingFile(t, repo, "a"); content != "A\nb\nC\n" {
+		t.Fatalf("a was merged as %q", content)
+	}
+	if content := readWorkingFile(t, repo, "d"); content != "d\n" {
+		t.Fatalf("d was checked out as %q", content)
+	}
+	if _, err := os.Stat(repo.workPath("c")); err == nil {
+		t.Fatal("c was deleted by us but came back")
+	}
+	status, err := repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.MergeHead == "" || strings.Join(status.Staged.Modified, " ") != "a" || strings.Join(status.Staged.Added, " ") != "d" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if _, err := repo.Commit("merge"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeConflict(t *testing.T) {
+	repo := newMergeRepository(t,
+		map[string]string{"a": "a\n", "b": "b\n"},
+		map[string]string{"a": "ours\n", "b": "B\n"},
+		map[string]string{"a": "theirs\n", "b": ""})
+	result, err := repo.Merge("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Conflicts, " ") != "a b" {
+		t.Fatalf("conflicts are %v", result.Conflicts)
+	}
+	if content := readWorkingFile(t, repo, "a"); content != "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> other\n" {
+		t.Fatalf("a was left as %q", content)
+	}
+	if content := readWorkingFile(t, repo, "b"); content != "B\n" {
+		t.Fatalf("b, modified by us and deleted by them, was left as %q", content)
+	}
+	if _, err := repo.Commit("merge"); err == nil {
+		t.Fatal("committed with unmerged paths")
+	}
+	writeWorkingFile(t, repo, "a", "both\n")
+	if err := repo.Add([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := repo.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Unmerged) != 0 {
+		t.Fatalf("still unmerged: %v", status.Unmerged)
+	}
+	if _, err := repo.Commit("merge"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeKeepsModeChanges(t *testing.T) {
+	repo, _ := newMemoryRepository(t)
+	oid := commitFiles(t, repo, "base", map[string]string{"f": "f\n", "g": "g\n"})
+	if err := repo.CreateBranch("other", oid); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Checkout("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(repo.workPath("f"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Add([]string{"f"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit("chmod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, repo, "ours", map[string]string{"g": "G\n"})
+	result, err := repo.Merge("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Fatalf("conflicts are %v", result.Conflicts)
+	}
+	info, err := os.Stat(repo.workPath("f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0111 == 0 {
+		t.Fatal("f lost its executable bit")
+	}
+	oid, err = repo.Commit("merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.getCommitTree(oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes, err := repo.getTreeModes(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modes["f"] != MODE_EXECUTABLE {
+		t.Fatalf("f was committed with mode %o", modes["f"])
+	}
+}
@@ -0,0 +1,76 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// A Repository is a data.Repository along with the store its objects are
+// read from and written to.
+type Repository struct {
+	*data.Repository
+	// the repository's object directory, unless replaced (by a
+	// data.MemoryStore, say)
+	Objects data.ObjectStore
+}
+
+func NewRepository(repo *data.Repository) *Repository {
+	return &Repository{Repository: repo, Objects: data.NewFileStore(repo)}
+}
+
+// workPath is where path, relative to the worktree and slash separated, is
+// on disk.
+func (repo *Repository) workPath(path string) string {
+	return filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+}
+
+// getObject opens an object, failing unless it has the expected type (""
+// accepts any).
+func (repo *Repository) getObject(oid string, expected string) (io.ReadCloser, error) {
+	type_, fh, err := repo.Objects.Get(oid)
+	if err != nil {
+		return nil, err
+	}
+	if expected != "" && expected != type_ {
+		fh.Close()
+		return nil, errors.New(fmt.Sprintf("object %s is a %s, not a %s", oid, type_, expected))
+	}
+	return fh, nil
+}
+
+func (repo *Repository) getObjectType(oid string) (string, error) {
+	type_, fh, err := repo.Objects.Get(oid)
+	if err != nil {
+		return "", err
+	}
+	fh.Close()
+	return type_, nil
+}
+
+// readObject returns the type and whole content of an object.
+func (repo *Repository) readObject(oid string) (type_ string, content []byte, err error) {
+	type_, fh, err := repo.Objects.Get(oid)
+	if err != nil {
+		return "", nil, err
+	}
+	defer fh.Close()
+	content, err = io.ReadAll(fh)
+	return type_, content, err
+}
+
+// findObjects lists the stored oids that start with prefix.
+func (repo *Repository) findObjects(prefix string) ([]string, error) {
+	oids := []string{}
+	err := repo.Objects.Iterate(func(oid string) error {
+		if strings.HasPrefix(oid, prefix) {
+			oids = append(oids, oid)
+		}
+		return nil
+	})
+	return oids, err
+}
@@ -0,0 +1,219 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+// abbreviated oids shorter than this are not looked up
+const MIN_ABBREV_LEN int = 4
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveName turns the part of a revision before any operator into an oid:
+// a ref (HEAD, a branch, a tag, refs/...), a full oid or a unique prefix.
+func (repo *Repository) resolveName(name string) (string, error) {
+	if name == "@" {
+		name = data.HEAD
+	}
+	candidates := []string{"refs/" + name, data.TAGS_PREFIX + name, data.HEADS_PREFIX + name}
+	if name == data.HEAD || name == data.MERGE_HEAD || strings.HasPrefix(name, "refs/") {
+		candidates = append([]string{name}, candidates...)
+	}
+	for _, ref := range candidates {
+		if repo.RefExists(ref) {
+			value, err := repo.GetRef(ref, true)
+			if err != nil {
+				return "", err
+			}
+			if value.Value == "" {
+				return "", errors.New(fmt.Sprintf("%s does not point at a commit yet", name))
+			}
+			return value.Value, nil
+		}
+	}
+	if name == data.HEAD {
+		return "", errors.New("HEAD does not point at a commit yet")
+	}
+
+	prefix := strings.ToLower(name)
+	if len(prefix) >= MIN_ABBREV_LEN && len(prefix) <= data.OID_LEN && isHex(prefix) {
+		if repo.Objects.Has(prefix) {
+			return prefix, nil
+		}
+		matches, err := repo.findObjects(prefix)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		} else if len(matches) > 1 {
+			return "", errors.New(fmt.Sprintf("short oid %s is ambiguous: %s", prefix, strings.Join(matches, ", ")))
+		}
+	}
+	return "", errors.New(fmt.Sprintf("unknown revision '%s'", name))
+}
+
+// ReflogRef works out which ref's log name@{n} refers to: HEAD for "" or
+// HEAD, otherwise a branch or a full ref name.
+func (repo *Repository) ReflogRef(name string) (string, error) {
+	if name == "" || name == "@" || name == data.HEAD {
+		return data.HEAD, nil
+	} else if repo.IsBranch(name) {
+		return data.HEADS_PREFIX + name, nil
+	} else if strings.HasPrefix(name, "refs/") && repo.RefExists(name) {
+		return name, nil
+	}
+	return "", errors.New(fmt.Sprintf("'%s' is not HEAD or a branch", name))
+}
+
+// resolveReflog returns where name pointed n updates ago.
+func (repo *Repository) resolveReflog(name string, n int) (string, error) {
+	ref, err := repo.ReflogRef(name)
+	if err != nil {
+		return "", err
+	}
+	entries, err := repo.ReadReflog(ref)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", errors.New(fmt.Sprintf("log for '%s' only has %d entries", ref, len(entries)))
+	}
+	return entries[len(entries)-1-n].New, nil
+}
+
+func (repo *Repository) getParent(oid string, n int) (string, error) {
+	oid, err := repo.Peel(oid, "commit")
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return oid, nil
+	}
+	commit, err := repo.GetCommit(oid)
+	if err != nil {
+		return "", err
+	}
+	if n > len(commit.GetParents()) {
+		return "", errors.New(fmt.Sprintf("%s has no parent %d", oid, n))
+	}
+	return commit.GetParents()[n-1], nil
+}
+
+// parseCount reads the optional number after '~' or '^', defaulting to 1.
+func parseCount(expr string) (n int, rest string, err error) {
+	end := 0
+	for end < len(expr) && expr[end] >= '0' && expr[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return 1, expr, nil
+	}
+	n, err = strconv.Atoi(expr[:end])
+	return n, expr[end:], err
+}
+
+// RevParse resolves a revision expression to an oid. Besides names (see
+// resolveName) it understands these suffixes, which may be chained:
+//
+//	ref@{n}    where HEAD or a branch pointed n updates ago, from its reflog
+//	rev~n      the n-th generation ancestor, following first parents
+//	rev^n      the n-th parent (rev^0 is the commit itself)
+//	rev^{type} peel tags and commits until an object of type is reached
+//	rev^{}     peel tags until something other than a tag is reached
+func (repo *Repository) RevParse(expr string) (string, error) {
+	idx := strings.IndexAny(expr, "~^")
+	if idx < 0 {
+		idx = len(expr)
+	}
+	if idx == 0 {
+		return "", errors.New(fmt.Sprintf("invalid revision '%s'", expr))
+	}
+	var oid string
+	var err error
+	if name, selector, found := strings.Cut(expr[:idx], "@{"); found {
+		n, err := strconv.Atoi(strings.TrimSuffix(selector, "}"))
+		if err != nil || n < 0 || !strings.HasSuffix(selector, "}") {
+			return "", errors.New(fmt.Sprintf("invalid revision '%s': expected @{n}", expr))
+		}
+		oid, err = repo.resolveReflog(name, n)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		oid, err = repo.resolveName(expr[:idx])
+		if err != nil {
+			return "", err
+		}
+	}
+	rest := expr[idx:]
+	for rest != "" {
+		op := rest[0]
+		rest = rest[1:]
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return "", errors.New(fmt.Sprintf("invalid revision '%s': unterminated ^{", expr))
+			}
+			type_ := rest[1:end]
+			rest = rest[end+1:]
+			if type_ == "" {
+				oid, err = repo.peelTags(oid)
+			} else {
+				oid, err = repo.Peel(oid, type_)
+			}
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		if op != '^' && op != '~' {
+			return "", errors.New(fmt.Sprintf("invalid revision '%s'", expr))
+		}
+		n, remaining, err := parseCount(rest)
+		if err != nil {
+			return "", err
+		}
+		rest = remaining
+		if op == '^' {
+			oid, err = repo.getParent(oid, n)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			oid, err = repo.getParent(oid, 1)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return oid, nil
+}
+
+func (repo *Repository) peelTags(oid string) (string, error) {
+	for {
+		type_, err := repo.getObjectType(oid)
+		if err != nil || type_ != "tag" {
+			return oid, err
+		}
+		tag, err := repo.GetTag(oid)
+		if err != nil {
+			return "", err
+		}
+		oid = tag.GetObject()
+	}
+}
@@ -0,0 +1,137 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRevParse(t *testing.T) {
+	repo, store := newMemoryRepository(t)
+	c1 := commitFiles(t, repo, "c1", map[string]string{"a": "1\n"})
+	if err := repo.CreateBranch("side", c1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Checkout("side"); err != nil {
+		t.Fatal(err)
+	}
+	s1 := commitFiles(t, repo, "s1", map[string]string{"s": "s\n"})
+	if err := repo.Checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	c2 := commitFiles(t, repo, "c2", map[string]string{"a": "2\n"})
+	c3 := commitFiles(t, repo, "c3", map[string]string{"a": "3\n"})
+	if _, err := repo.Merge("side"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := repo.Commit("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateTag("light", c2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateTag("v1", m, "release"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := repo.resolveName("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if type_, err := repo.getObjectType(tag); err != nil || type_ != "tag" {
+		t.Fatalf("v1 is a %s, not an annotated tag (%v)", type_, err)
+	}
+	tree, err := repo.getCommitTree(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"HEAD", m},
+		{"@", m},
+		{"main", m},
+		{"refs/heads/side", s1},
+		{"HEAD^", c3},
+		{"HEAD^1", c3},
+		{"HEAD^2", s1},
+		{"HEAD^0", m},
+		{"HEAD~", c3},
+		{"HEAD~2", c2},
+		{"HEAD~3", c1},
+		{"HEAD^^", c2},
+		{"HEAD~2^", c1},
+		{"HEAD^2~1", c1},
+		{"light", c2},
+		{"v1", tag},
+		{"v1^{}", m},
+		{"v1^{commit}", m},
+		{"v1^{tree}", tree},
+		{"v1~1", c3},
+		{"@{0}", m},
+		{"main@{0}", m},
+		{"main@{1}", c3},
+		{"main@{3}", c1},
+		{"main@{1}~1", c2},
+		{c1, c1},
+		{c1[:12], c1},
+		{strings.ToUpper(s1[:8]), s1},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			oid, err := repo.RevParse(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if oid != test.want {
+				t.Fatalf("got %s, want %s", oid, test.want)
+			}
+		})
+	}
+
+	// add blobs until two share an abbreviation
+	abbrev := ""
+	seen := map[string]bool{}
+	for i := 0; abbrev == ""; i++ {
+		oid, err := store.Put(strings.NewReader(fmt.Sprintf("blob %d\n", i)), "blob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[oid[:MIN_ABBREV_LEN]] {
+			abbrev = oid[:MIN_ABBREV_LEN]
+		}
+		seen[oid[:MIN_ABBREV_LEN]] = true
+	}
+
+	failures := []struct {
+		expr string
+		want string
+	}{
+		{"nope", "unknown revision"},
+		{"~1", "invalid revision"},
+		{"HEAD^3", "has no parent 3"},
+		{"HEAD~4", "has no parent 1"},
+		{"HEAD^{tree", "unterminated"},
+		{"HEAD^{tree}~1", ""},
+		{"HEAD^{blob}", ""},
+		{"main@{4}", "only has 4 entries"},
+		{"main@{x}", "expected @{n}"},
+		{"main@{1", "expected @{n}"},
+		{"light@{0}", "is not HEAD or a branch"},
+		{c1[:MIN_ABBREV_LEN-1], "unknown revision"},
+		{abbrev, "ambiguous"},
+	}
+	for _, test := range failures {
+		t.Run(test.expr, func(t *testing.T) {
+			oid, err := repo.RevParse(test.expr)
+			if err == nil {
+				t.Fatalf("resolved to %s", oid)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("error %q does not mention %q", err, test.want)
+			}
+		})
+	}
+}
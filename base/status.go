@@ -0,0 +1,221 @@
+package base
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"jerroyd.com/ugit/data"
+)
+
+type Changes struct {
+	Added    []string
+	Modified []string
+	Deleted  []string
+}
+
+func (c Changes) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0
+}
+
+type Status struct {
+	Branch    string   // "" when HEAD is detached
+	Head      string   // "" before the first commit
+	MergeHead string   // set while a merge awaits its commit
+	Unmerged  []string // paths left in conflict by the merge
+	Staged    Changes
+	Unstaged  Changes
+	Untracked []string
+}
+
+// diffTreeMaps compares two path -> oid maps. A path is also modified when
+// its mode changed; modes missing from either side are not compared.
+func diffTreeMaps(from map[string]string, to map[string]string, fromModes map[string]uint32, toModes map[string]uint32) Changes {
+	changes := Changes{}
+	for path, oid := range to {
+		fromOid, ok := from[path]
+		fromMode, hasFromMode := fromModes[path]
+		toMode, hasToMode := toModes[path]
+		if !ok {
+			changes.Added = append(changes.Added, path)
+		} else if fromOid != oid || (hasFromMode && hasToMode && fromMode != toMode) {
+			changes.Modified = append(changes.Modified, path)
+		}
+	}
+	for path := range from {
+		if _, ok := to[path]; !ok {
+			changes.Deleted = append(changes.Deleted, path)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+	return changes
+}
+
+// isStatClean reports whether a file still has the size and mtime recorded
+// in the index, in which case it is assumed to still hash to entry.Oid.
+func isStatClean(entry *IndexEntry, info fs.FileInfo) bool {
+	return entry.GetMtime() != 0 &&
+		entry.GetSize() == info.Size() &&
+		entry.GetMtime() == info.ModTime().UnixNano()
+}
+
+// diffIndexWorkingTree compares the index against the working directory.
+// Entries whose content turns out unchanged get their stat information
+// refreshed; refreshed reports whether that happened.
+func (repo *Repository) diffIndexWorkingTree(index map[string]*IndexEntry) (changes Changes, refreshed bool, err error) {
+	for _, entry := range sortedIndexEntries(index) {
+		path := entry.GetPath()
+		info, err := os.Lstat(repo.workPath(path))
+		if errors.Is(err, os.ErrNotExist) || (err == nil && !isWorkingFile(info)) {
+			changes.Deleted = append(changes.Deleted, path)
+			continue
+		} else if err != nil {
+			return Changes{}, false, err
+		}
+		// a chmod leaves the size and mtime alone
+		if workingMode(entry, info) != indexMode(entry) {
+			changes.Modified = append(changes.Modified, path)
+			continue
+		}
+		if isStatClean(entry, info) {
+			continue
+		}
+		oid, _, err := repo.hashWorkingFile(path)
+		if err != nil {
+			return Changes{}, false, err
+		}
+		if oid != entry.GetOid() {
+			changes.Modified = append(changes.Modified, path)
+			continue
+		}
+		refreshedEntry := newIndexEntry(path, oid, info)
+		refreshedEntry.Mode = entry.GetMode()
+		index[path] = refreshedEntry
+		refreshed = true
+	}
+	return changes, refreshed, nil
+}
+
+// listUntracked walks the working directory for files missing from the
+// index. A directory without any tracked file is reported once, as "dir/".
+func (repo *Repository) listUntracked(index map[string]*IndexEntry) ([]string, error) {
+	trackedDirs := map[string]bool{}
+	for path := range index {
+		for dir := filepath.Dir(filepath.FromSlash(path)); dir != "."; dir = filepath.Dir(dir) {
+			trackedDirs[filepath.ToSlash(dir)] = true
+		}
+	}
+	untracked := []string{}
+	err := filepath.WalkDir(repo.workPath(""), func(full string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(repo.workPath(""), full)
+		if err != nil {
+			return err
+		}
+		path := cleanPath(rel)
+		if path == "" {
+			return nil
+		}
+		if isIgnored(path) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if !trackedDirs[path] {
+				if containsFiles(full) {
+					untracked = append(untracked, path+"/")
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := index[path]; !ok {
+			untracked = append(untracked, path)
+		}
+		return nil
+	})
+	return untracked, err
+}
+
+// containsFiles reports whether anything other than directories lives below
+// dir; like git, empty directories are not worth mentioning.
+func containsFiles(dir string) bool {
+	found := errors.New("found")
+	err := filepath.WalkDir(dir, func(full string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			return found
+		}
+		return nil
+	})
+	return err == found
+}
+
+func (repo *Repository) GetStatus() (Status, error) {
+	status := Status{}
+	if err := repo.CheckWorkTree(); err != nil {
+		return status, err
+	}
+	branch, err := repo.GetBranchName()
+	if err != nil {
+		return status, err
+	}
+	status.Branch = branch
+	status.Head, err = repo.GetHead()
+	if err != nil {
+		return status, err
+	}
+	mergeHead, err := repo.GetRef(data.MERGE_HEAD, false)
+	if err != nil {
+		return status, err
+	}
+	status.MergeHead = mergeHead.Value
+	status.Unmerged, err = repo.ReadMergeConflicts()
+	if err != nil {
+		return status, err
+	}
+	tree, err := repo.getCommitTree(status.Head)
+	if err != nil {
+		return status, err
+	}
+	headMap, err := repo.getTreeMap(tree)
+	if err != nil {
+		return status, err
+	}
+	headModes, err := repo.getTreeModes(tree)
+	if err != nil {
+		return status, err
+	}
+	// the index is refreshed below only if nobody else is changing it
+	lock, lockErr := repo.LockIndex()
+	if lockErr == nil {
+		defer lock.Rollback()
+	}
+	index, err := repo.readIndex()
+	if err != nil {
+		return status, err
+	}
+
+	indexSide := indexDiffSide(index)
+	status.Staged = diffTreeMaps(headMap, indexSide.files, headModes, indexSide.modes)
+
+	unstaged, refreshed, err := repo.diffIndexWorkingTree(index)
+	if err != nil {
+		return status, err
+	}
+	status.Unstaged = unstaged
+	if refreshed && lockErr == nil {
+		// not fatal; the next status will simply rehash again
+		repo.commitIndex(lock, index)
+	}
+
+	status.Untracked, err = repo.listUntracked(index)
+	return status, err
+}
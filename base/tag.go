@@ -0,0 +1,117 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"jerroyd.com/ugit/data"
+)
+
+func (repo *Repository) IsTag(name string) bool {
+	return name != "" && repo.RefExists(data.TAGS_PREFIX+name)
+}
+
+// CreateTag points refs/tags/<name> at target. With a message, an annotated
+// tag object recording the tagger is created and the ref points at it.
+func (repo *Repository) CreateTag(name string, target string, message string) error {
+	if err := data.CheckRefName(name); err != nil {
+		return err
+	}
+	if repo.IsTag(name) {
+		return errors.New(fmt.Sprintf("tag '%s' already exists", name))
+	}
+	if target == "" {
+		return errors.New(fmt.Sprintf("cannot create tag '%s': no commits yet", name))
+	}
+	oid := target
+	if message != "" {
+		type_, err := repo.getObjectType(target)
+		if err != nil {
+			return err
+		}
+		tagger, err := repo.GetIdent(data.ROLE_COMMITTER)
+		if err != nil {
+			return err
+		}
+		tag := TagInfo{
+			Object:  target,
+			Type_:   type_,
+			Tag:     name,
+			Tagger:  NewSignature(tagger),
+			Message: message,
+		}
+		oid, err = repo.hashMessage(&tag, "tag")
+		if err != nil {
+			return err
+		}
+	}
+	return repo.UpdateRef(data.TAGS_PREFIX+name, data.RefValue{Value: oid}, false, "tag: "+name)
+}
+
+func (repo *Repository) GetTag(oid string) (TagInfo, error) {
+	tag := TagInfo{}
+	err := repo.readMessage(oid, "tag", &tag)
+	return TagInfo{
+		Object:  tag.GetObject(),
+		Type_:   tag.GetType_(),
+		Tag:     tag.GetTag(),
+		Tagger:  tag.GetTagger(),
+		Message: tag.GetMessage(),
+	}, err
+}
+
+func (repo *Repository) IterTagNames() ([]string, error) {
+	refs, err := repo.IterRefs(data.TAGS_PREFIX, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, strings.TrimPrefix(ref.Name, data.TAGS_PREFIX))
+	}
+	return names, nil
+}
+
+func (repo *Repository) DeleteTag(name string) error {
+	if !repo.IsTag(name) {
+		return errors.New(fmt.Sprintf("tag '%s' not found", name))
+	}
+	return repo.DeleteRef(data.TAGS_PREFIX+name, false)
+}
+
+// Peel follows annotated tags (and, when asked for a tree, commits) from oid
+// until it reaches an object of type_.
+func (repo *Repository) Peel(oid string, type_ string) (string, error) {
+	for {
+		actual, err := repo.getObjectType(oid)
+		if err != nil {
+			return "", err
+		}
+		if actual == type_ {
+			return oid, nil
+		}
+		switch {
+		case actual == "tag":
+			tag, err := repo.GetTag(oid)
+			if err != nil {
+				return "", err
+			}
+			oid = tag.GetObject()
+		case actual == "commit" && type_ == "tree":
+			return repo.getCommitTree(oid)
+		default:
+			return "", errors.New(fmt.Sprintf("%s is a %s, not a %s", oid, actual, type_))
+		}
+	}
+}
+
+// GetCommitOid resolves a revision like RevParse, peeling tags down to the
+// commit.
+func (repo *Repository) GetCommitOid(name string) (string, error) {
+	oid, err := repo.RevParse(name)
+	if err != nil {
+		return "", err
+	}
+	return repo.Peel(oid, "commit")
+}
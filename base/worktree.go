@@ -0,0 +1,229 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"jerroyd.com/ugit/data"
+)
+
+// WorktreeInfo describes a worktree and what is checked out in it.
+type WorktreeInfo struct {
+	data.Worktree
+	Head   string // "" on an unborn branch
+	Branch string // "" when HEAD is detached
+}
+
+// openWorktree returns the repository as checked out in worktree, sharing
+// the object store of repo.
+func (repo *Repository) openWorktree(worktree data.Worktree) *Repository {
+	if worktree.GitDir == repo.GitDir {
+		return repo
+	}
+	return &Repository{Repository: repo.OpenWorktree(worktree), Objects: repo.Objects}
+}
+
+func (repo *Repository) describeWorktree(worktree data.Worktree) (WorktreeInfo, error) {
+	info := WorktreeInfo{Worktree: worktree}
+	checkout := repo.openWorktree(worktree)
+	head, err := checkout.GetHead()
+	if err != nil {
+		return info, err
+	}
+	info.Head = head
+	info.Branch, err = checkout.GetBranchName()
+	return info, err
+}
+
+// ListWorktrees describes the main worktree, then the linked ones.
+func (repo *Repository) ListWorktrees() ([]WorktreeInfo, error) {
+	worktrees, err := repo.Repository.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+	infos := []WorktreeInfo{}
+	for _, worktree := range worktrees {
+		info, err := repo.describeWorktree(worktree)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// checkedOutAt returns the worktree branch is checked out in, if any. A bare
+// repository does not check out the branch its HEAD is attached to.
+func (repo *Repository) checkedOutAt(branch string) (*WorktreeInfo, error) {
+	worktrees, err := repo.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+	for _, worktree := range worktrees {
+		if worktree.Path != "" && worktree.Branch == branch {
+			return &worktree, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddWorktree checks out a new worktree at path. With newBranch, a branch of
+// that name is created at name (HEAD by default) and checked out. Otherwise
+// name is checked out, detaching HEAD unless it is a branch; without a name,
+// the branch named after the worktree is, and is created at HEAD if need
+// be. A branch can only be checked out in one worktree at a time.
+func (repo *Repository) AddWorktree(path string, name string, newBranch string) (WorktreeInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return WorktreeInfo{}, err
+	}
+	if name == "" && newBranch == "" {
+		newBranch = filepath.Base(path)
+		if repo.IsBranch(newBranch) {
+			name, newBranch = newBranch, ""
+		}
+	}
+	var oid string
+	var headValue data.RefValue
+	if newBranch != "" {
+		if name == "" {
+			name = data.HEAD
+		}
+		oid, err = repo.GetCommitOid(name)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+		err = repo.CreateBranch(newBranch, oid)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+		headValue = data.RefValue{Symbolic: true, Value: data.HEADS_PREFIX + newBranch}
+	} else if repo.IsBranch(name) {
+		other, err := repo.checkedOutAt(name)
+		if err != nil {
+			return WorktreeInfo{}, err
+		} else if other != nil {
+			return WorktreeInfo{}, errors.New(fmt.Sprintf("'%s' is already checked out at %s", name, other.Path))
+		}
+		ref, err := repo.GetRef(data.HEADS_PREFIX+name, true)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+		oid = ref.Value
+		headValue = data.RefValue{Symbolic: true, Value: data.HEADS_PREFIX + name}
+	} else {
+		oid, err = repo.GetCommitOid(name)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+		headValue = data.RefValue{Value: oid}
+	}
+
+	linked, err := repo.Repository.AddWorktree(path)
+	if err != nil {
+		if newBranch != "" {
+			// nobody has seen the branch yet
+			repo.DeleteRef(data.HEADS_PREFIX+newBranch, false)
+		}
+		return WorktreeInfo{}, err
+	}
+	checkout := &Repository{Repository: linked, Objects: repo.Objects}
+	err = checkout.UpdateRef(data.HEAD, headValue, false, "worktree add: "+path)
+	if err != nil {
+		return WorktreeInfo{}, err
+	}
+	if oid != "" {
+		tree, err := checkout.getCommitTree(oid)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+		err = checkout.ReadTree(tree)
+		if err != nil {
+			return WorktreeInfo{}, err
+		}
+	}
+	worktree := data.Worktree{Name: filepath.Base(linked.GitDir), Path: linked.WorkTree, GitDir: linked.GitDir}
+	return checkout.describeWorktree(worktree)
+}
+
+// RemoveWorktree deletes the linked worktree at path. Unless force is set,
+// it must not have changes, staged or not, or untracked files.
+func (repo *Repository) RemoveWorktree(path string, force bool) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	worktrees, err := repo.Repository.ListWorktrees()
+	if err != nil {
+		return err
+	}
+	for _, worktree := range worktrees {
+		if worktree.Path != path {
+			continue
+		}
+		if !force && !worktree.IsMain() {
+			status, err := repo.openWorktree(worktree).GetStatus()
+			if err != nil {
+				return err
+			}
+			if !status.Staged.IsEmpty() || !status.Unstaged.IsEmpty() || len(status.Untracked) > 0 {
+				return errors.New(fmt.Sprintf("'%s' contains modified or untracked files, use -f to remove it anyway", path))
+			}
+		}
+		return repo.Repository.RemoveWorktree(worktree)
+	}
+	return errors.New(fmt.Sprintf("'%s' is not a worktree", path))
+}
+
+// otherWorktreeRoots lists what the other worktrees keep alive: their HEAD,
+// MERGE_HEAD and index, and apart from those, what their HEAD reflogs
+// remember.
+func (repo *Repository) otherWorktreeRoots() (roots []fsckLink, logged []fsckLink, err error) {
+	worktrees, err := repo.Repository.ListWorktrees()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, worktree := range worktrees {
+		if worktree.GitDir == repo.GitDir {
+			continue
+		}
+		checkout := repo.openWorktree(worktree)
+		of := " of worktree " + worktree.GitDir
+		head, err := checkout.GetHead()
+		if err != nil {
+			return nil, nil, err
+		}
+		if head != "" {
+			roots = append(roots, fsckLink{from: data.HEAD + of, oid: head, type_: "commit"})
+		}
+		mergeHead, err := checkout.GetRef(data.MERGE_HEAD, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if mergeHead.Value != "" {
+			roots = append(roots, fsckLink{from: data.MERGE_HEAD + of, oid: mergeHead.Value, type_: "commit"})
+		}
+		if !checkout.IsBare() {
+			index, err := checkout.readIndex()
+			if err != nil {
+				return nil, nil, err
+			}
+			for path, entry := range index {
+				roots = append(roots, fsckLink{from: "index entry " + path + of, oid: entry.GetOid(), type_: "blob"})
+			}
+		}
+		entries, err := checkout.ReadReflog(data.HEAD)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			for _, oid := range []string{entry.Old, entry.New} {
+				if oid != "" {
+					logged = append(logged, fsckLink{from: "reflog of " + data.HEAD + of, oid: oid, type_: "commit"})
+				}
+			}
+		}
+	}
+	return roots, logged, nil
+}
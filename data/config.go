@@ -0,0 +1,188 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The config file follows git's ini-like format:
+//
+//	[user]
+//		name = Jane Doe
+//	[remote "origin"]
+//		url = ...
+//
+// Keys are addressed as "section.key", or "section.subsection.key".
+
+const GLOBAL_CONFIG string = ".ugitconfig"
+
+type configEntry struct {
+	section string
+	key     string
+	value   string
+}
+
+func splitConfigKey(key string) (section string, name string, err error) {
+	idx := strings.LastIndex(key, ".")
+	if idx <= 0 || idx == len(key)-1 {
+		return "", "", errors.New(fmt.Sprintf("config key '%s' must look like section.key", key))
+	}
+	return strings.ToLower(key[:idx]), strings.ToLower(key[idx+1:]), nil
+}
+
+func readConfigFile(path string) ([]configEntry, error) {
+	fh, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []configEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	entries := []configEntry{}
+	section := ""
+	scanner := bufio.NewScanner(fh)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			name, sub, hasSub := strings.Cut(header, " ")
+			section = strings.ToLower(name)
+			if hasSub {
+				section += "." + strings.Trim(strings.TrimSpace(sub), "\"")
+			}
+			continue
+		}
+		if section == "" {
+			return nil, errors.New(fmt.Sprintf("%s:%d: key outside of a section", path, lineNo))
+		}
+		key, value, _ := strings.Cut(line, "=")
+		entries = append(entries, configEntry{
+			section: section,
+			key:     strings.ToLower(strings.TrimSpace(key)),
+			value:   strings.Trim(strings.TrimSpace(value), "\""),
+		})
+	}
+	return entries, scanner.Err()
+}
+
+func writeConfigFile(w io.Writer, entries []configEntry) error {
+	var sb strings.Builder
+	sections := []string{}
+	bySection := map[string][]configEntry{}
+	for _, entry := range entries {
+		if _, ok := bySection[entry.section]; !ok {
+			sections = append(sections, entry.section)
+		}
+		bySection[entry.section] = append(bySection[entry.section], entry)
+	}
+	for _, section := range sections {
+		name, sub, hasSub := strings.Cut(section, ".")
+		if hasSub {
+			fmt.Fprintf(&sb, "[%s \"%s\"]\n", name, sub)
+		} else {
+			fmt.Fprintf(&sb, "[%s]\n", name)
+		}
+		for _, entry := range bySection[section] {
+			fmt.Fprintf(&sb, "\t%s = %s\n", entry.key, entry.value)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (repo *Repository) configPath(global bool) (string, error) {
+	if !global {
+		return filepath.Join(repo.CommonDir, "config"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, GLOBAL_CONFIG), nil
+}
+
+func lookupConfig(path string, section string, name string) (string, bool, error) {
+	entries, err := readConfigFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	value, found := "", false
+	for _, entry := range entries {
+		if entry.section == section && entry.key == name {
+			value, found = entry.value, true // last one wins
+		}
+	}
+	return value, found, nil
+}
+
+// GetConfig looks key up in the repository config, then in ~/.ugitconfig.
+// A missing key is "" without an error.
+func (repo *Repository) GetConfig(key string) (string, error) {
+	section, name, err := splitConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+	for _, global := range []bool{false, true} {
+		path, err := repo.configPath(global)
+		if err != nil {
+			continue
+		}
+		value, found, err := lookupConfig(path, section, name)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+func (repo *Repository) SetConfig(key string, value string, global bool) error {
+	section, name, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+	if !global {
+		if err := repo.checkInitialized(); err != nil {
+			return err
+		}
+	}
+	path, err := repo.configPath(global)
+	if err != nil {
+		return err
+	}
+	// hold the lock while reading, so that concurrent changes are not lost
+	lock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	entries, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range entries {
+		if entries[i].section == section && entries[i].key == name {
+			entries[i].value = value
+			found = true
+		}
+	}
+	if !found {
+		entries = append(entries, configEntry{section: section, key: name, value: value})
+	}
+	if err := writeConfigFile(lock, entries); err != nil {
+		return err
+	}
+	return lock.commit()
+}
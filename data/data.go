@@ -1,6 +1,9 @@
 package data
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
@@ -8,71 +11,296 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const GIT_DIR string = ".ugit"
 const OID_LEN int = 40
 
-func assertInitialized() {
-	_, err := os.Stat(GIT_DIR)
+// checkInitialized fails unless the git dir exists.
+func (repo *Repository) checkInitialized() error {
+	info, err := os.Stat(repo.GitDir)
+	if err == nil && !info.IsDir() {
+		err = errors.New("not a directory")
+	}
 	if err != nil {
-		panic("ugit not initialized")
+		return errors.New(fmt.Sprintf("not a ugit repository: %s", repo.GitDir))
 	}
+	return nil
 }
 func checkFileExists(filePath string) bool {
 	_, error := os.Stat(filePath)
 	return !errors.Is(error, os.ErrNotExist)
 }
 
-func Initialize() error {
-	err := os.Mkdir(GIT_DIR, os.FileMode(0755))
+// Initialize creates an empty repository. objectFormat is OBJECT_FORMAT_UGIT
+// or OBJECT_FORMAT_GIT; "" means the former. A bare repository is laid out
+// directly in its git dir, which may already exist as long as it is not a
+// repository yet.
+func (repo *Repository) Initialize(objectFormat string) error {
+	if objectFormat != "" && objectFormat != OBJECT_FORMAT_UGIT && objectFormat != OBJECT_FORMAT_GIT {
+		return errors.New(fmt.Sprintf("unknown object format '%s'", objectFormat))
+	}
+	var err error
+	if repo.IsBare() {
+		if isGitDir(repo.GitDir) {
+			return errors.New(fmt.Sprintf("%s is already a repository", repo.GitDir))
+		}
+		err = os.MkdirAll(repo.GitDir, os.FileMode(0755))
+	} else {
+		err = os.Mkdir(repo.GitDir, os.FileMode(0755))
+	}
 	if err != nil {
 		return err
 	}
-	err = os.Mkdir(filepath.Join(GIT_DIR, "objects"), os.FileMode(0755))
-	return err
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		err = os.MkdirAll(filepath.Join(repo.GitDir, filepath.FromSlash(dir)), os.FileMode(0755))
+		if err != nil {
+			return err
+		}
+	}
+	err = repo.SetConfig("core.repositoryformatversion", strconv.Itoa(REPOSITORY_FORMAT_VERSION), false)
+	if err != nil {
+		return err
+	}
+	if objectFormat == OBJECT_FORMAT_GIT {
+		err = repo.SetConfig("core.objectformat", objectFormat, false)
+		if err != nil {
+			return err
+		}
+	}
+	if repo.IsBare() {
+		err = repo.SetConfig("core.bare", "true", false)
+		if err != nil {
+			return err
+		}
+	}
+	return repo.UpdateRef(HEAD, RefValue{Symbolic: true, Value: HEADS_PREFIX + DEFAULT_BRANCH}, false, "init")
+}
+
+// REPOSITORY_FORMAT_VERSION is written to core.repositoryformatversion by
+// init. Repositories without it are version 0, where an oid is the sha1 of
+// the content alone. From version 1 on it also covers a "type size\0" header.
+const REPOSITORY_FORMAT_VERSION int = 1
+
+func (repo *Repository) getFormatVersion() (version int, err error) {
+	path, err := repo.configPath(false)
+	if err != nil {
+		return 0, err
+	}
+	value, found, err := lookupConfig(path, "core", "repositoryformatversion")
+	if err != nil || !found {
+		return 0, err
+	}
+	version, err = strconv.Atoi(value)
+	if err != nil || version < 0 || version > REPOSITORY_FORMAT_VERSION {
+		return 0, errors.New(fmt.Sprintf("unsupported repository format version '%s'", value))
+	}
+	return version, nil
 }
 
-func GetHead() (oid string, err error) {
-	file := filepath.Join(GIT_DIR, "HEAD")
-	if !checkFileExists(file) {
-		return "", nil
+// Trees, commits and tags are encoded either with ugit's own protobuf
+// messages, or byte for byte like git's loose objects. Git formatted objects
+// are kept in git's objects/ab/cdef... layout, so that git can read them.
+const OBJECT_FORMAT_UGIT string = "ugit"
+const OBJECT_FORMAT_GIT string = "git"
+
+// GetObjectFormat reads core.objectformat. The git format needs the object
+// header to be hashed, i.e. format version 1.
+func (repo *Repository) GetObjectFormat() (format string, err error) {
+	path, err := repo.configPath(false)
+	if err != nil {
+		return "", err
 	}
-	fh, err := os.Open(file)
+	format, _, err = lookupConfig(path, "core", "objectformat")
 	if err != nil {
 		return "", err
 	}
-	defer fh.Close()
-	// read the type off the beginning, and return the at beginning of data.
-	buf := make([]byte, OID_LEN)
-	bytesRead, err := fh.Read(buf)
-	if bytesRead != OID_LEN {
-		return "", errors.New("GetHead failed: unexpected read length")
-	} else if err != nil && err != io.EOF {
+	switch format {
+	case "", OBJECT_FORMAT_UGIT:
+		return OBJECT_FORMAT_UGIT, nil
+	case OBJECT_FORMAT_GIT:
+		version, err := repo.getFormatVersion()
+		if err != nil {
+			return "", err
+		}
+		if version < 1 {
+			return "", errors.New("the git object format needs repository format version 1")
+		}
+		return format, nil
+	}
+	return "", errors.New(fmt.Sprintf("unknown object format '%s'", format))
+}
+
+// objectPaths lists where oid may be stored: fanned out into a directory
+// named after its first two digits, or flat in objects/ as older repos did.
+func (repo *Repository) objectPaths(oid string) []string {
+	dir := repo.objectsDir()
+	paths := []string{}
+	if len(oid) > 2 {
+		paths = append(paths, filepath.Join(dir, oid[:2], oid[2:]))
+	}
+	return append(paths, filepath.Join(dir, oid))
+}
+
+// findObject returns the path oid is stored at, or "" if there is none.
+func (repo *Repository) findObject(oid string) string {
+	for _, path := range repo.objectPaths(oid) {
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// newObjectPath is where a new object is written, always fanned out so that
+// no single directory grows too large.
+func (repo *Repository) newObjectPath(oid string) (path string, err error) {
+	path = repo.objectPaths(oid)[0]
+	err = os.MkdirAll(filepath.Dir(path), os.FileMode(0755))
+	return path, err
+}
+
+// iterObjectFiles lists every loose object, in either layout, as oid -> path.
+func (repo *Repository) iterObjectFiles() (map[string]string, error) {
+	dir := repo.objectsDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	isOid := func(name string) bool {
+		_, err := hex.DecodeString(name)
+		return err == nil && strings.ToLower(name) == name
+	}
+	objects := map[string]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && len(name) == OID_LEN && isOid(name) {
+			objects[name] = filepath.Join(dir, name)
+		} else if entry.IsDir() && len(name) == 2 && isOid(name) {
+			fanout, err := os.ReadDir(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, object := range fanout {
+				oid := name + object.Name()
+				if object.Type().IsRegular() && len(oid) == OID_LEN && isOid(oid) {
+					objects[oid] = filepath.Join(dir, name, object.Name())
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+func objectHeader(type_ string, size int64) string {
+	if size < 0 {
+		return type_ + string('\000')
+	}
+	return fmt.Sprintf("%s %d\000", type_, size)
+}
+
+// spoolObject copies fi to a temporary file, so its size is known before
+// anything is hashed. The caller removes the file.
+func (repo *Repository) spoolObject(fi io.Reader) (fh *os.File, size int64, err error) {
+	fh, err = repo.createTemp("tmp_spool_")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err = io.Copy(fh, fi)
+	if err == nil {
+		_, err = fh.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return nil, 0, err
+	}
+	return fh, size, nil
+}
+
+// prepareObject works out the header for an object of type_, and returns
+// the reader its content should be read from afterwards. The header is only
+// hashed from format version 1 on; size is -1 before that.
+func (repo *Repository) prepareObject(fi io.Reader, type_ string) (r io.Reader, header string, hashed bool, cleanup func(), err error) {
+	cleanup = func() {}
+	version, err := repo.getFormatVersion()
+	if err != nil {
+		return nil, "", false, cleanup, err
+	}
+	if version < 1 {
+		return fi, objectHeader(type_, -1), false, cleanup, nil
+	}
+	spool, size, err := repo.spoolObject(fi)
+	if err != nil {
+		return nil, "", false, cleanup, err
+	}
+	cleanup = func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	return spool, objectHeader(type_, size), true, cleanup, nil
+}
+
+// ComputeOid returns the oid fi would be stored under, without storing it.
+func (repo *Repository) ComputeOid(fi io.Reader, type_ string) (oid string, err error) {
+	r, header, hashed, cleanup, err := repo.prepareObject(fi, type_)
+	defer cleanup()
+	if err != nil {
+		return "", err
+	}
+	hasher := sha1.New()
+	if hashed {
+		hasher.Write([]byte(header))
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
 		return "", err
 	}
-	return string(buf[:]), nil
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
-func SetHead(oid string) error {
-	file := filepath.Join(GIT_DIR, "HEAD")
-	return os.WriteFile(file, []byte(oid), 0660)
+
+func (repo *Repository) HashObject(fi io.Reader, type_ string) (oid string, err error) {
+	return repo.hashObject(fi, type_, false)
+}
+
+func freshen(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
 }
 
-func HashObject(fi io.Reader, type_ string) (oid string, err error) {
-	assertInitialized()
+// hashObject stores an object, as a loose file even if it is already packed
+// when loose is set.
+func (repo *Repository) hashObject(fi io.Reader, type_ string, loose bool) (oid string, err error) {
+	if err := repo.checkInitialized(); err != nil {
+		return "", err
+	}
+	if type_ == "" {
+		type_ = "blob"
+	}
+	fi, header, hashed, cleanup, err := repo.prepareObject(fi, type_)
+	defer cleanup()
+	if err != nil {
+		return "", err
+	}
 	hasher := sha1.New()
 	buf := make([]byte, 1024)
 
-	fo, err := os.CreateTemp("", "")
+	fo, err := repo.createTemp("tmp_obj_")
 	if err != nil {
 		return "", err
 	}
+	defer os.Remove(fo.Name()) // a no-op once renamed into place
 	defer fo.Close()
+	zw := zlib.NewWriter(fo)
 	// write type
-	if type_ == "" {
-		type_ = "blob"
+	if hashed {
+		hasher.Write([]byte(header))
 	}
-	fo.Write([]byte(type_ + string('\000')))
+	zw.Write([]byte(header))
 	// write data
 	for {
 		bytesRead, err := fi.Read(buf)
@@ -82,16 +310,32 @@ func HashObject(fi io.Reader, type_ string) (oid string, err error) {
 			break
 		}
 		hasher.Write(buf[:bytesRead])
-		if _, err := fo.Write(buf[:bytesRead]); err != nil {
+		if _, err := zw.Write(buf[:bytesRead]); err != nil {
 			return "", err
 		}
 	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
 	oid = hex.EncodeToString(hasher.Sum(nil))
 
-	// Move tempfile to {GIT_DIR}/objects/{oid}
-	path := filepath.Join(GIT_DIR, "objects", oid)
+	// Move tempfile to {GIT_DIR}/objects/{oid}, unless it is already stored.
+	// The existing copy is freshened so that gc does not prune it
+	// from under whoever is about to refer to it.
+	if file := repo.findObject(oid); file != "" {
+		freshen(file)
+		return oid, nil
+	} else if pack := repo.findPacked(oid); pack != nil && !loose {
+		freshen(pack.Path)
+		return oid, nil
+	}
+	path, err := repo.newObjectPath(oid)
+	if err != nil {
+		return "", err
+	}
 	if !checkFileExists(path) {
-		err = os.Rename(fo.Name(), path)
+		// a racing writer may get there first, but with the same content
+		err = renameSynced(fo, path)
 		if err != nil {
 			fmt.Printf("Err renaming type %s", type_)
 		}
@@ -100,31 +344,523 @@ func HashObject(fi io.Reader, type_ string) (oid string, err error) {
 	return oid, err
 }
 
-func GetObject(oid string, expected_type string) (fh *os.File, err error) {
-	assertInitialized()
-	file := filepath.Join(GIT_DIR, "objects", oid)
-	fh, err = os.Open(file)
+// objectReader reads an object's content, after its header, and closes both
+// the decompressor and the underlying file. When the header declared a size,
+// reading fails unless the content matches it.
+type objectReader struct {
+	*bufio.Reader
+	closers   []io.Closer
+	oid       string
+	remaining int64
+}
+
+func (r *objectReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if r.remaining < 0 {
+		return n, err
+	}
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errors.New(fmt.Sprintf("object %s is longer than its declared size", r.oid))
+	}
+	if err == io.EOF && r.remaining > 0 {
+		return n, errors.New(fmt.Sprintf("object %s is shorter than its declared size", r.oid))
+	}
+	return n, err
+}
+
+func (r *objectReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// isZlibHeader recognizes the two byte zlib stream header. Objects written
+// before compression was introduced start with an ascii type name instead,
+// which never passes this check.
+func isZlibHeader(b []byte) bool {
+	return len(b) >= 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// openObject opens an object, compressed or not, and reads its type header.
+func (repo *Repository) openObject(oid string) (r *objectReader, type_ string, err error) {
+	if err := repo.checkInitialized(); err != nil {
+		return nil, "", err
+	}
+	file := repo.findObject(oid)
+	if file == "" {
+		return repo.openPackedObject(oid)
+	}
+	return openObjectFile(oid, file)
+}
+
+func openObjectFile(oid string, file string) (r *objectReader, type_ string, err error) {
+	fh, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	r = &objectReader{Reader: bufio.NewReader(fh), closers: []io.Closer{fh}, oid: oid, remaining: -1}
+	magic, err := r.Peek(2)
+	if err == nil && isZlibHeader(magic) {
+		zr, err := zlib.NewReader(r.Reader)
+		if err != nil {
+			r.Close()
+			return nil, "", err
+		}
+		r.closers = append(r.closers, zr)
+		r.Reader = bufio.NewReader(zr)
 	}
 	// read the type off the beginning, and return the at beginning of data.
-	buf := make([]byte, 1024)
-	bytesRead, err := fh.Read(buf)
-	if err != nil && err != io.EOF {
-		return nil, err
-	} else if bytesRead == 0 {
-		return nil, errors.New("GetObject failed: unexpected empty file")
+	header, err := r.ReadString('\000')
+	if err == io.EOF && header == "" {
+		r.Close()
+		return nil, "", errors.New("GetObject failed: unexpected empty file")
+	} else if err != nil {
+		r.Close()
+		return nil, "", errors.New(fmt.Sprintf("GetObject failed: no type header in %s", oid))
 	}
-	// find the null
-	nullIdx := 0
-	for nullIdx < bytesRead && buf[nullIdx] != '\000' {
-		nullIdx++
+	// "type size\0", or just "type\0" in format version 0
+	type_, size, hasSize := strings.Cut(strings.TrimSuffix(header, "\000"), " ")
+	if hasSize {
+		r.remaining, err = strconv.ParseInt(size, 10, 64)
+		if err != nil || r.remaining < 0 {
+			r.Close()
+			return nil, "", errors.New(fmt.Sprintf("GetObject failed: bad size '%s' in %s", size, oid))
+		}
 	}
+	return r, type_, nil
+}
 
+func (repo *Repository) GetObject(oid string, expected_type string) (fh io.ReadCloser, err error) {
+	r, type_, err := repo.openObject(oid)
+	if err != nil {
+		return nil, err
+	}
 	// enforce type checking
-	if expected_type != "" && expected_type != string(buf[0:nullIdx]) {
-		return nil, errors.New(fmt.Sprintf("GetObject failed. type %s != %s", expected_type, string(buf[0:nullIdx])))
+	if expected_type != "" && expected_type != type_ {
+		r.Close()
+		return nil, errors.New(fmt.Sprintf("GetObject failed. type %s != %s", expected_type, type_))
+	}
+	return r, nil
+}
+
+func (repo *Repository) ObjectExists(oid string) bool {
+	if len(oid) != OID_LEN {
+		return false
+	}
+	return repo.findObject(oid) != "" || repo.findPacked(oid) != nil
+}
+
+// listObjects lists every stored oid, loose or packed, in order.
+func (repo *Repository) listObjects() ([]string, error) {
+	if err := repo.checkInitialized(); err != nil {
+		return nil, err
+	}
+	objects, err := repo.iterObjectFiles()
+	if err != nil {
+		return nil, err
+	}
+	packs, err := repo.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		for _, oid := range pack.Oids() {
+			objects[oid] = pack.Path
+		}
+	}
+	oids := make([]string, 0, len(objects))
+	for oid := range objects {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+	return oids, nil
+}
+
+// FindObjects lists the stored oids that start with prefix.
+func (repo *Repository) FindObjects(prefix string) ([]string, error) {
+	all, err := repo.listObjects()
+	if err != nil {
+		return nil, err
+	}
+	oids := []string{}
+	for _, oid := range all {
+		if strings.HasPrefix(oid, prefix) {
+			oids = append(oids, oid)
+		}
+	}
+	return oids, nil
+}
+
+// loadPacks lists the packs in objects/pack.
+func (repo *Repository) loadPacks() ([]*Pack, error) {
+	idxs, err := filepath.Glob(filepath.Join(repo.objectsDir(), "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	repo.packsLock.Lock()
+	defer repo.packsLock.Unlock()
+	packs := []*Pack{}
+	for _, idx := range idxs {
+		pack, ok := repo.packs[idx]
+		if !ok {
+			pack, err = OpenPack(idx)
+			if err != nil {
+				return nil, err
+			}
+			repo.packs[idx] = pack
+		}
+		packs = append(packs, pack)
+	}
+	return packs, nil
+}
+
+func (repo *Repository) findPacked(oid string) *Pack {
+	packs, err := repo.loadPacks()
+	if err != nil {
+		return nil
+	}
+	for _, pack := range packs {
+		if pack.Has(oid) {
+			return pack
+		}
+	}
+	return nil
+}
+
+func (repo *Repository) openPackedObject(oid string) (r *objectReader, type_ string, err error) {
+	pack := repo.findPacked(oid)
+	if pack == nil {
+		return nil, "", errors.New(fmt.Sprintf("object %s not found", oid))
+	}
+	type_, content, err := pack.ReadObject(oid, repo.ReadObject)
+	if err != nil {
+		return nil, "", err
+	}
+	r = &objectReader{Reader: bufio.NewReader(bytes.NewReader(content)), oid: oid, remaining: -1}
+	return r, type_, nil
+}
+
+// ReadObject returns the type and whole content of an object, loose or
+// packed.
+func (repo *Repository) ReadObject(oid string) (type_ string, content []byte, err error) {
+	r, type_, err := repo.openObject(oid)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	content, err = io.ReadAll(r)
+	return type_, content, err
+}
+
+// Repack moves objects, loose or in older packs, into a single new pack, and
+// removes what it replaced. With keep, only the objects it accepts are
+// packed: the others stay loose, and those that were packed are written back
+// out loose with the time of their pack, so they can still expire. It
+// returns how many objects were packed and how many of them as deltas.
+func (repo *Repository) Repack(keep func(oid string) bool) (count int, deltaCount int, err error) {
+	if err := repo.checkInitialized(); err != nil {
+		return 0, 0, err
+	}
+	loose, err := repo.iterObjectFiles()
+	if err != nil {
+		return 0, 0, err
+	}
+	oldPacks, err := repo.loadPacks()
+	if err != nil {
+		return 0, 0, err
+	}
+	oids := map[string]bool{}
+	for oid := range loose {
+		oids[oid] = true
+	}
+	for _, pack := range oldPacks {
+		for _, oid := range pack.Oids() {
+			oids[oid] = true
+		}
+	}
+	objects := make([]PackObject, 0, len(oids))
+	for oid := range oids {
+		if keep != nil && !keep(oid) {
+			if _, isLoose := loose[oid]; !isLoose {
+				if err := repo.unpackObject(oid); err != nil {
+					return 0, 0, err
+				}
+			}
+			delete(loose, oid)
+			continue
+		}
+		type_, content, err := repo.ReadObject(oid)
+		if err != nil {
+			return 0, 0, err
+		}
+		objects = append(objects, PackObject{Oid: oid, Type: type_, Content: content})
+	}
+
+	path := ""
+	if len(objects) > 0 {
+		dir := filepath.Join(repo.objectsDir(), "pack")
+		if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+			return 0, 0, err
+		}
+		path, deltaCount, err = WritePack(dir, objects)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, pack := range oldPacks {
+		if pack.Path == path {
+			continue // nothing changed since the last repack
+		}
+		idx := strings.TrimSuffix(pack.Path, ".pack") + ".idx"
+		repo.packsLock.Lock()
+		delete(repo.packs, idx)
+		repo.packsLock.Unlock()
+		if err := os.Remove(idx); err != nil {
+			return 0, 0, err
+		}
+		if err := os.Remove(pack.Path); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, file := range loose {
+		if err := repo.removeLooseObject(file); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(objects), deltaCount, nil
+}
+
+// unpackObject writes a packed object out loose, dated like its pack.
+func (repo *Repository) unpackObject(oid string) error {
+	pack := repo.findPacked(oid)
+	if pack == nil {
+		return errors.New(fmt.Sprintf("object %s not found", oid))
+	}
+	type_, content, err := repo.ReadObject(oid)
+	if err != nil {
+		return err
+	}
+	stored, err := repo.hashObject(bytes.NewReader(content), type_, true)
+	if err != nil {
+		return err
+	}
+	if stored != oid {
+		return errors.New(fmt.Sprintf("object %s rehashes as %s", oid, stored))
+	}
+	info, err := os.Stat(pack.Path)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(repo.findObject(oid), info.ModTime(), info.ModTime())
+}
+
+func (repo *Repository) removeLooseObject(file string) error {
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(file); dir != repo.objectsDir() {
+		os.Remove(dir) // a fan-out directory, once empty
 	}
-	fh.Seek(int64(nullIdx+1), 0) // skip the null character
-	return fh, nil
+	return nil
+}
+
+// PruneObjects removes the loose objects keep does not accept, unless they
+// were written after before. It returns how many were removed.
+func (repo *Repository) PruneObjects(keep func(oid string) bool, before time.Time) (count int, err error) {
+	if err := repo.checkInitialized(); err != nil {
+		return 0, err
+	}
+	loose, err := repo.iterObjectFiles()
+	if err != nil {
+		return 0, err
+	}
+	for oid, file := range loose {
+		if keep(oid) {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return count, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		if err := repo.removeLooseObject(file); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (repo *Repository) GetObjectType(oid string) (type_ string, err error) {
+	r, type_, err := repo.openObject(oid)
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+	return type_, nil
+}
+
+// MigrateObjects compresses objects written before compression was
+// introduced and moves objects stored flat in objects/ into their fan-out
+// directory. It returns how many were compressed and how many moved; their
+// oids do not change.
+func (repo *Repository) MigrateObjects() (compressed int, moved int, err error) {
+	if err := repo.checkInitialized(); err != nil {
+		return 0, 0, err
+	}
+	objects, err := repo.iterObjectFiles()
+	if err != nil {
+		return 0, 0, err
+	}
+	for oid, path := range objects {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return compressed, moved, err
+		}
+		target := repo.objectPaths(oid)[0]
+		if path != target {
+			if repo.findObject(oid) == target {
+				// already fanned out too, so the flat copy is redundant
+				if err := os.Remove(path); err != nil {
+					return compressed, moved, err
+				}
+				moved++
+				continue
+			}
+			if target, err = repo.newObjectPath(oid); err != nil {
+				return compressed, moved, err
+			}
+		}
+		if isZlibHeader(raw) {
+			if path != target {
+				if err := os.Rename(path, target); err != nil {
+					return compressed, moved, err
+				}
+				if err := syncDir(filepath.Dir(target)); err != nil {
+					return compressed, moved, err
+				}
+				moved++
+			}
+			continue
+		}
+		fo, err := os.CreateTemp(filepath.Dir(target), "tmp_obj_")
+		if err != nil {
+			return compressed, moved, err
+		}
+		zw := zlib.NewWriter(fo)
+		_, err = zw.Write(raw)
+		if err == nil {
+			err = zw.Close()
+		}
+		if err == nil {
+			err = renameSynced(fo, target)
+		} else {
+			fo.Close()
+		}
+		if err != nil {
+			os.Remove(fo.Name())
+			return compressed, moved, err
+		}
+		compressed++
+		if path != target {
+			if err := os.Remove(path); err != nil {
+				return compressed, moved, err
+			}
+			moved++
+		}
+	}
+	return compressed, moved, nil
+}
+
+// ReadIndex returns the raw index file. The error wraps os.ErrNotExist when
+// nothing has been staged yet.
+func (repo *Repository) ReadIndex() ([]byte, error) {
+	if err := repo.checkInitialized(); err != nil {
+		return nil, err
+	}
+	if err := repo.CheckWorkTree(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(repo.GitDir, "index"))
+}
+
+// MERGE_CONFLICTS lists, one per line, the paths a merge left in conflict
+// that have not been added since.
+const MERGE_CONFLICTS string = "MERGE_CONFLICTS"
+
+// ReadMergeConflicts returns the paths still in conflict, none outside of a
+// merge.
+func (repo *Repository) ReadMergeConflicts() ([]string, error) {
+	buf, err := os.ReadFile(filepath.Join(repo.GitDir, MERGE_CONFLICTS))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(buf)), nil
+}
+
+// WriteMergeConflicts records the paths still in conflict; with none, the
+// record is removed.
+func (repo *Repository) WriteMergeConflicts(paths []string) error {
+	file := filepath.Join(repo.GitDir, MERGE_CONFLICTS)
+	if len(paths) == 0 {
+		err := os.Remove(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return writeFileLocked(file, []byte(strings.Join(paths, "\n")+"\n"))
+}
+
+func (repo *Repository) WriteIndex(buf []byte) error {
+	lock, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	return lock.Commit(buf)
+}
+
+// An IndexLock is held from before the index is read until the changed
+// index is written, so that changes made meanwhile are not lost.
+type IndexLock struct {
+	lock *lockFile
+}
+
+// LockIndex takes the lock on the index, failing if someone else holds it.
+func (repo *Repository) LockIndex() (*IndexLock, error) {
+	if err := repo.checkInitialized(); err != nil {
+		return nil, err
+	}
+	if err := repo.CheckWorkTree(); err != nil {
+		return nil, err
+	}
+	lock, err := lock(filepath.Join(repo.GitDir, "index"))
+	if err != nil {
+		return nil, err
+	}
+	return &IndexLock{lock: lock}, nil
+}
+
+// Commit replaces the index with buf and releases the lock.
+func (index *IndexLock) Commit(buf []byte) error {
+	if _, err := index.lock.Write(buf); err != nil {
+		index.lock.rollback()
+		return err
+	}
+	return index.lock.commit()
+}
+
+// Rollback releases the lock and leaves the index alone. It is a no-op once
+// the lock has been committed.
+func (index *IndexLock) Rollback() {
+	index.lock.rollback()
 }
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"bufio"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A GitRepo reads objects and refs out of a git repository, loose or packed.
+type GitRepo struct {
+	Dir   string
+	packs []*Pack
+}
+
+// OpenGitRepo opens either a git directory or a work tree containing one.
+func OpenGitRepo(path string) (*GitRepo, error) {
+	dir := path
+	if checkFileExists(filepath.Join(path, ".git", "objects")) {
+		dir = filepath.Join(path, ".git")
+	}
+	if !checkFileExists(filepath.Join(dir, "objects")) || !checkFileExists(filepath.Join(dir, "HEAD")) {
+		return nil, errors.New(fmt.Sprintf("'%s' is not a git repository", path))
+	}
+	repo := &GitRepo{Dir: dir}
+	idxs, err := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		pack, err := OpenPack(idx)
+		if err != nil {
+			return nil, err
+		}
+		repo.packs = append(repo.packs, pack)
+	}
+	return repo, nil
+}
+
+func (repo *GitRepo) readLooseObject(oid string) (type_ string, content []byte, err error) {
+	fh, err := os.Open(filepath.Join(repo.Dir, "objects", oid[:2], oid[2:]))
+	if err != nil {
+		return "", nil, err
+	}
+	defer fh.Close()
+	zr, err := zlib.NewReader(fh)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+	header, err := r.ReadString('\000')
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("bad header in git object %s", oid))
+	}
+	type_, sizeStr, _ := strings.Cut(strings.TrimSuffix(header, "\000"), " ")
+	size, err := strconv.ParseUint(sizeStr, 10, 64)
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("bad header in git object %s", oid))
+	}
+	content, err = io.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(content)) != size {
+		return "", nil, errors.New(fmt.Sprintf("git object %s does not match its declared size", oid))
+	}
+	return type_, content, nil
+}
+
+// ReadObject returns the type and content of a git object.
+func (repo *GitRepo) ReadObject(oid string) (type_ string, content []byte, err error) {
+	if len(oid) != OID_LEN {
+		return "", nil, errors.New(fmt.Sprintf("invalid oid '%s'", oid))
+	}
+	type_, content, err = repo.readLooseObject(oid)
+	if !errors.Is(err, os.ErrNotExist) {
+		return type_, content, err
+	}
+	for _, pack := range repo.packs {
+		if pack.Has(oid) {
+			return pack.ReadObject(oid, repo.ReadObject)
+		}
+	}
+	return "", nil, errors.New(fmt.Sprintf("git object %s not found", oid))
+}
+
+func (repo *GitRepo) readRefFile(name string) (RefValue, error) {
+	buf, err := os.ReadFile(filepath.Join(repo.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		return RefValue{}, err
+	}
+	value := strings.TrimSpace(string(buf))
+	if strings.HasPrefix(value, "ref:") {
+		return RefValue{Symbolic: true, Value: strings.TrimSpace(strings.TrimPrefix(value, "ref:"))}, nil
+	}
+	return RefValue{Value: value}, nil
+}
+
+// Head returns HEAD without following it.
+func (repo *GitRepo) Head() (RefValue, error) {
+	return repo.readRefFile(HEAD)
+}
+
+// IterRefs lists the branches and tags, loose ones taking precedence over
+// packed-refs. Symbolic refs are skipped.
+func (repo *GitRepo) IterRefs() ([]NamedRef, error) {
+	refs := map[string]string{}
+	packed, err := os.ReadFile(filepath.Join(repo.Dir, "packed-refs"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		// "#" starts the header, "^" the peeled value of the tag above
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		oid, name, found := strings.Cut(line, " ")
+		if found {
+			refs[name] = oid
+		}
+	}
+	for _, prefix := range []string{HEADS_PREFIX, TAGS_PREFIX} {
+		root := filepath.Join(repo.Dir, filepath.FromSlash(prefix))
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			} else if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(repo.Dir, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			value, err := repo.readRefFile(name)
+			if err != nil {
+				return err
+			}
+			if !value.Symbolic {
+				refs[name] = value.Value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	list := []NamedRef{}
+	for name, oid := range refs {
+		if strings.HasPrefix(name, HEADS_PREFIX) || strings.HasPrefix(name, TAGS_PREFIX) {
+			list = append(list, NamedRef{Name: name, Value: RefValue{Value: oid}})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
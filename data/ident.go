@@ -0,0 +1,130 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ROLE_AUTHOR string = "AUTHOR"
+const ROLE_COMMITTER string = "COMMITTER"
+
+// An Ident says who did something, and when.
+type Ident struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (ident Ident) String() string {
+	return fmt.Sprintf("%s <%s> %s", ident.Name, ident.Email, FormatTimestamp(ident.When))
+}
+
+// FormatTimestamp renders t the way git stores it: "<unix seconds> +hhmm".
+func FormatTimestamp(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%d %c%02d%02d", t.Unix(), sign, offset/3600, offset/60%60)
+}
+
+func parseTimezone(tz string) (*time.Location, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, errors.New(fmt.Sprintf("invalid timezone '%s'", tz))
+	}
+	hours, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return nil, err
+	}
+	minutes, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return nil, err
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
+
+// ParseTimestamp accepts git's internal "<unix seconds> +hhmm" format (with an
+// optional leading '@'), RFC 3339 and RFC 2822 dates.
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	fields := strings.Fields(strings.TrimPrefix(value, "@"))
+	if len(fields) >= 1 && len(fields) <= 2 {
+		if secs, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			when := time.Unix(secs, 0).UTC()
+			if len(fields) == 2 {
+				loc, err := parseTimezone(fields[1])
+				if err != nil {
+					return time.Time{}, err
+				}
+				when = when.In(loc)
+			}
+			return when, nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339, time.RFC1123Z, "Mon, 2 Jan 2006 15:04:05 -0700"} {
+		if when, err := time.Parse(layout, value); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("unrecognized date '%s'", value))
+}
+
+// GetIdent works out who is acting in role (ROLE_AUTHOR or ROLE_COMMITTER),
+// from UGIT_<ROLE>_NAME, UGIT_<ROLE>_EMAIL and UGIT_<ROLE>_DATE, then the
+// user.name and user.email config, then the login name and host.
+func (repo *Repository) GetIdent(role string) (Ident, error) {
+	ident := Ident{
+		Name:  os.Getenv("UGIT_" + role + "_NAME"),
+		Email: os.Getenv("UGIT_" + role + "_EMAIL"),
+		When:  time.Now(),
+	}
+	if date := os.Getenv("UGIT_" + role + "_DATE"); date != "" {
+		when, err := ParseTimestamp(date)
+		if err != nil {
+			return Ident{}, err
+		}
+		ident.When = when
+	}
+	var err error
+	if ident.Name == "" {
+		if ident.Name, err = repo.GetConfig("user.name"); err != nil {
+			return Ident{}, err
+		}
+	}
+	if ident.Email == "" {
+		if ident.Email, err = repo.GetConfig("user.email"); err != nil {
+			return Ident{}, err
+		}
+	}
+	if ident.Email == "" {
+		ident.Email = os.Getenv("EMAIL")
+	}
+	if ident.Name == "" || ident.Email == "" {
+		login := "unknown"
+		if current, err := user.Current(); err == nil {
+			login = current.Username
+		}
+		if ident.Name == "" {
+			ident.Name = login
+		}
+		if ident.Email == "" {
+			host, _ := os.Hostname()
+			ident.Email = login + "@" + host
+		}
+	}
+	if strings.ContainsAny(ident.Name+ident.Email, "<>\n") {
+		return Ident{}, errors.New(fmt.Sprintf("invalid identity '%s <%s>'", ident.Name, ident.Email))
+	}
+	return ident, nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Files are never rewritten in place. A new version is written next to the
+// old one and renamed over it once it has reached the disk, so a crash
+// leaves either the old or the new version behind, never half of one.
+
+// LOCK_SUFFIX marks a file being rewritten. Whoever creates path+LOCK_SUFFIX
+// owns path until the lock is renamed over it or removed.
+const LOCK_SUFFIX string = ".lock"
+
+type lockFile struct {
+	*os.File
+	path string // the file being replaced
+}
+
+// lock takes the lock on path, failing if someone else holds it.
+func lock(path string) (*lockFile, error) {
+	fh, err := os.OpenFile(path+LOCK_SUFFIX, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0660)
+	if errors.Is(err, os.ErrExist) {
+		return nil, errors.New(fmt.Sprintf("cannot lock %s: %s exists; another ugit process may be running, otherwise remove it", path, path+LOCK_SUFFIX))
+	} else if err != nil {
+		return nil, err
+	}
+	return &lockFile{File: fh, path: path}, nil
+}
+
+// commit replaces the locked file with what was written to the lock.
+func (lock *lockFile) commit() error {
+	err := lock.Sync()
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lock.Name(), lock.path)
+	}
+	if err != nil {
+		os.Remove(lock.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(lock.path))
+}
+
+// rollback releases the lock and leaves the file alone. It is a no-op once
+// the lock has been committed.
+func (lock *lockFile) rollback() {
+	if lock.Close() == nil {
+		os.Remove(lock.Name())
+	}
+}
+
+// writeFileLocked replaces path with content while holding its lock.
+func writeFileLocked(path string, content []byte) error {
+	lock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	if _, err := lock.Write(content); err != nil {
+		return err
+	}
+	return lock.commit()
+}
+
+// syncDir flushes a directory, so that files renamed into it survive a crash.
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return fh.Sync()
+}
+
+// createTemp creates a temporary file inside the repository, on the same
+// filesystem as the objects it will be renamed to.
+func (repo *Repository) createTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(repo.objectsDir(), pattern)
+}
+
+// renameSynced flushes fo to disk and moves it to path.
+func renameSynced(fo *os.File, path string) error {
+	err := fo.Sync()
+	if closeErr := fo.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fo.Name(), path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+	}
+	return err
+}
@@ -0,0 +1,582 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Packfiles and their indexes, in git's pack version 2 and idx version 2
+// formats. A pack is a sequence of zlib compressed objects, some of them
+// stored as deltas against another object; the index maps oids to offsets
+// in the pack.
+
+const OBJ_COMMIT int = 1
+const OBJ_TREE int = 2
+const OBJ_BLOB int = 3
+const OBJ_TAG int = 4
+const OBJ_OFS_DELTA int = 6
+const OBJ_REF_DELTA int = 7
+
+// deltas are only followed this deep, which also catches cycles
+const MAX_DELTA_DEPTH int = 50
+
+var packTypeNames = map[int]string{
+	OBJ_COMMIT: "commit",
+	OBJ_TREE:   "tree",
+	OBJ_BLOB:   "blob",
+	OBJ_TAG:    "tag",
+}
+
+var idxMagic = []byte{0xff, 't', 'O', 'c'}
+
+// A Pack is an opened .pack file and its .idx, with the oids sorted.
+type Pack struct {
+	Path    string
+	oids    []string
+	offsets []uint64
+}
+
+// OpenPack reads the index of a pack. path is either the .pack or the .idx.
+func OpenPack(path string) (*Pack, error) {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".idx"), ".pack") + ".pack"
+	idxPath := strings.TrimSuffix(path, ".pack") + ".idx"
+	buf, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8+256*4 || !bytes.Equal(buf[:4], idxMagic) || binary.BigEndian.Uint32(buf[4:8]) != 2 {
+		return nil, errors.New(fmt.Sprintf("%s is not a version 2 pack index", idxPath))
+	}
+	count := int(binary.BigEndian.Uint32(buf[8+255*4:]))
+	oidStart := 8 + 256*4
+	crcStart := oidStart + count*20
+	offsetStart := crcStart + count*4
+	largeStart := offsetStart + count*4
+	if len(buf) < largeStart+40 {
+		return nil, errors.New(fmt.Sprintf("%s is truncated", idxPath))
+	}
+	pack := &Pack{Path: path, oids: make([]string, count), offsets: make([]uint64, count)}
+	for i := 0; i < count; i++ {
+		pack.oids[i] = hex.EncodeToString(buf[oidStart+i*20 : oidStart+(i+1)*20])
+		offset := uint64(binary.BigEndian.Uint32(buf[offsetStart+i*4:]))
+		if offset&0x80000000 != 0 {
+			large := largeStart + int(offset&0x7fffffff)*8
+			if large+8 > len(buf)-40 {
+				return nil, errors.New(fmt.Sprintf("%s has a bad offset for %s", idxPath, pack.oids[i]))
+			}
+			offset = binary.BigEndian.Uint64(buf[large:])
+		}
+		pack.offsets[i] = offset
+	}
+	return pack, nil
+}
+
+// Oids lists the objects in the pack, sorted.
+func (pack *Pack) Oids() []string {
+	return pack.oids
+}
+
+func (pack *Pack) find(oid string) (offset uint64, found bool) {
+	i := sort.SearchStrings(pack.oids, oid)
+	if i < len(pack.oids) && pack.oids[i] == oid {
+		return pack.offsets[i], true
+	}
+	return 0, false
+}
+
+func (pack *Pack) Has(oid string) bool {
+	_, found := pack.find(oid)
+	return found
+}
+
+// ReadObject returns the type and content of oid. Deltas against objects
+// outside of the pack are resolved through external, which may be nil.
+func (pack *Pack) ReadObject(oid string, external func(oid string) (string, []byte, error)) (type_ string, content []byte, err error) {
+	offset, found := pack.find(oid)
+	if !found {
+		return "", nil, errors.New(fmt.Sprintf("object %s not found in %s", oid, pack.Path))
+	}
+	fh, err := os.Open(pack.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer fh.Close()
+	return pack.readAt(fh, offset, external, 0)
+}
+
+func readPackHeader(r *bufio.Reader) (type_ int, size uint64, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	type_ = int(b>>4) & 7
+	size = uint64(b & 0x0f)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= uint64(b&0x7f) << shift
+	}
+	return type_, size, nil
+}
+
+// readOfsDelta decodes the distance back to an OBJ_OFS_DELTA's base.
+func readOfsDelta(r *bufio.Reader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	distance := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		distance = (distance+1)<<7 | uint64(b&0x7f)
+	}
+	return distance, nil
+}
+
+func inflate(r io.Reader, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (pack *Pack) readAt(fh *os.File, offset uint64, external func(string) (string, []byte, error), depth int) (string, []byte, error) {
+	if depth > MAX_DELTA_DEPTH {
+		return "", nil, errors.New(fmt.Sprintf("delta chain too deep in %s", pack.Path))
+	}
+	r := bufio.NewReader(io.NewSectionReader(fh, int64(offset), 1<<62))
+	type_, size, err := readPackHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	var baseType string
+	var base []byte
+	switch type_ {
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		content, err := inflate(r, size)
+		return packTypeNames[type_], content, err
+	case OBJ_OFS_DELTA:
+		distance, err := readOfsDelta(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if distance == 0 || distance > offset {
+			return "", nil, errors.New(fmt.Sprintf("bad delta offset in %s", pack.Path))
+		}
+		baseType, base, err = pack.readAt(fh, offset-distance, external, depth+1)
+		if err != nil {
+			return "", nil, err
+		}
+	case OBJ_REF_DELTA:
+		baseOid := make([]byte, 20)
+		if _, err := io.ReadFull(r, baseOid); err != nil {
+			return "", nil, err
+		}
+		oid := hex.EncodeToString(baseOid)
+		if baseOffset, found := pack.find(oid); found {
+			baseType, base, err = pack.readAt(fh, baseOffset, external, depth+1)
+		} else if external != nil {
+			baseType, base, err = external(oid)
+		} else {
+			err = errors.New(fmt.Sprintf("delta base %s not found", oid))
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	default:
+		return "", nil, errors.New(fmt.Sprintf("unknown object type %d in %s", type_, pack.Path))
+	}
+	delta, err := inflate(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := ApplyDelta(base, delta)
+	return baseType, content, err
+}
+
+func readDeltaSize(delta []byte) (size uint64, rest []byte, err error) {
+	for shift := 0; len(delta) > 0; shift += 7 {
+		b := delta[0]
+		delta = delta[1:]
+		size |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+	return 0, nil, errors.New("truncated delta")
+}
+
+// ApplyDelta rebuilds an object from its base and a git delta: the two
+// sizes, then instructions that either copy a range of the base or insert
+// literal bytes.
+func ApplyDelta(base []byte, delta []byte) ([]byte, error) {
+	baseSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, errors.New("delta does not match its base")
+	}
+	size, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > len(delta) {
+				return nil, errors.New("bad delta insert")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+			continue
+		}
+		var offset, length uint64
+		for i := 0; i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errors.New("truncated delta copy")
+			}
+			if i < 4 {
+				offset |= uint64(delta[0]) << (8 * i)
+			} else {
+				length |= uint64(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if length == 0 {
+			length = 0x10000
+		}
+		if offset+length > uint64(len(base)) {
+			return nil, errors.New("delta copy out of range")
+		}
+		out = append(out, base[offset:offset+length]...)
+	}
+	if uint64(len(out)) != size {
+		return nil, errors.New("delta produced the wrong size")
+	}
+	return out, nil
+}
+
+// DELTA_BLOCK is the size of the chunks of a base that CreateDelta looks for
+// in the target.
+const DELTA_BLOCK int = 16
+
+// a single copy instruction moves at most this much
+const MAX_DELTA_COPY int = 0x10000
+
+func appendDeltaSize(buf []byte, size int) []byte {
+	for size >= 0x80 {
+		buf = append(buf, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+	return append(buf, byte(size))
+}
+
+func appendDeltaInsert(buf []byte, insert []byte) []byte {
+	for len(insert) > 0 {
+		n := len(insert)
+		if n > 0x7f {
+			n = 0x7f
+		}
+		buf = append(buf, byte(n))
+		buf = append(buf, insert[:n]...)
+		insert = insert[n:]
+	}
+	return buf
+}
+
+func appendDeltaCopy(buf []byte, offset int, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > MAX_DELTA_COPY {
+			n = MAX_DELTA_COPY
+		}
+		op := byte(0x80)
+		args := []byte{}
+		for i := 0; i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				op |= 1 << i
+				args = append(args, b)
+			}
+		}
+		if n != MAX_DELTA_COPY { // a size of 0 means MAX_DELTA_COPY
+			for i := 0; i < 3; i++ {
+				if b := byte(n >> (8 * i)); b != 0 {
+					op |= 1 << (4 + i)
+					args = append(args, b)
+				}
+			}
+		}
+		buf = append(append(buf, op), args...)
+		offset += n
+		length -= n
+	}
+	return buf
+}
+
+// CreateDelta encodes target as copies out of base and literal inserts, in
+// the format ApplyDelta reads. Runs of DELTA_BLOCK bytes found in base are
+// copied, extended as far as both sides agree.
+func CreateDelta(base []byte, target []byte) []byte {
+	delta := appendDeltaSize(appendDeltaSize(nil, len(base)), len(target))
+	index := map[string]int{}
+	for i := len(base) - DELTA_BLOCK; i >= 0; i -= DELTA_BLOCK {
+		index[string(base[i:i+DELTA_BLOCK])] = i // the earliest wins
+	}
+	insertStart := 0
+	for pos := 0; pos+DELTA_BLOCK <= len(target); {
+		offset, found := index[string(target[pos:pos+DELTA_BLOCK])]
+		if !found {
+			pos++
+			continue
+		}
+		length := DELTA_BLOCK
+		for offset+length < len(base) && pos+length < len(target) && base[offset+length] == target[pos+length] {
+			length++
+		}
+		for pos > insertStart && offset > 0 && base[offset-1] == target[pos-1] {
+			pos--
+			offset--
+			length++
+		}
+		delta = appendDeltaInsert(delta, target[insertStart:pos])
+		delta = appendDeltaCopy(delta, offset, length)
+		pos += length
+		insertStart = pos
+	}
+	return appendDeltaInsert(delta, target[insertStart:])
+}
+
+// A PackObject is an object to be written to a pack.
+type PackObject struct {
+	Oid     string
+	Type    string
+	Content []byte
+}
+
+func appendPackHeader(buf []byte, type_ int, size int) []byte {
+	b := byte(type_<<4) | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		buf = append(buf, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(buf, b)
+}
+
+func appendOfsDelta(buf []byte, distance uint64) []byte {
+	encoded := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		encoded = append([]byte{byte(distance&0x7f) | 0x80}, encoded...)
+	}
+	return append(buf, encoded...)
+}
+
+func deflate(content []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(content)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// deltas are only tried against this many of the objects before
+const DELTA_WINDOW int = 10
+
+// and chains are kept shorter than this, so reading stays cheap
+const MAX_PACK_DEPTH int = 10
+
+// chooseDeltas picks, for each object, a similar object of the same type
+// earlier in the list to store it as a delta against, or -1. Objects are
+// sorted so that likely bases come first.
+func chooseDeltas(objects []PackObject) (bases []int, deltas [][]byte) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].Type != objects[j].Type {
+			return objects[i].Type < objects[j].Type
+		}
+		return len(objects[i].Content) > len(objects[j].Content)
+	})
+	bases = make([]int, len(objects))
+	deltas = make([][]byte, len(objects))
+	depth := make([]int, len(objects))
+	for i, object := range objects {
+		bases[i] = -1
+		if len(object.Content) < 2*DELTA_BLOCK {
+			continue
+		}
+		for j := i - 1; j >= 0 && j >= i-DELTA_WINDOW; j-- {
+			if objects[j].Type != object.Type || depth[j] >= MAX_PACK_DEPTH {
+				continue
+			}
+			delta := CreateDelta(objects[j].Content, object.Content)
+			if len(delta) < len(object.Content)/2 && (deltas[i] == nil || len(delta) < len(deltas[i])) {
+				bases[i], deltas[i], depth[i] = j, delta, depth[j]+1
+			}
+		}
+	}
+	return bases, deltas
+}
+
+// WritePack stores objects as a new pack and index in dir, named after the
+// pack's checksum like git does. It returns the path of the pack and how
+// many objects were stored as deltas.
+func WritePack(dir string, objects []PackObject) (path string, deltaCount int, err error) {
+	bases, deltas := chooseDeltas(objects)
+	fo, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(fo.Name()) // a no-op once renamed into place
+	defer fo.Close()
+	hasher := sha1.New()
+	w := io.MultiWriter(fo, hasher)
+
+	header := append([]byte("PACK"), 0, 0, 0, 2)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(objects)))
+	if _, err := w.Write(header); err != nil {
+		return "", 0, err
+	}
+	offsets := make([]uint64, len(objects))
+	crcs := make([]uint32, len(objects))
+	offset := uint64(len(header))
+	for i, object := range objects {
+		var entry []byte
+		if bases[i] >= 0 {
+			entry = appendPackHeader(nil, OBJ_OFS_DELTA, len(deltas[i]))
+			entry = appendOfsDelta(entry, offset-offsets[bases[i]])
+			entry = append(entry, deflate(deltas[i])...)
+			deltaCount++
+		} else {
+			type_ := 0
+			for number, name := range packTypeNames {
+				if name == object.Type {
+					type_ = number
+				}
+			}
+			if type_ == 0 {
+				return "", 0, errors.New(fmt.Sprintf("cannot pack %s of type %s", object.Oid, object.Type))
+			}
+			entry = appendPackHeader(nil, type_, len(object.Content))
+			entry = append(entry, deflate(object.Content)...)
+		}
+		if _, err := w.Write(entry); err != nil {
+			return "", 0, err
+		}
+		offsets[i] = offset
+		crcs[i] = crc32.ChecksumIEEE(entry)
+		offset += uint64(len(entry))
+	}
+	checksum := hasher.Sum(nil)
+	if _, err := fo.Write(checksum); err != nil {
+		return "", 0, err
+	}
+	if err := fo.Chmod(0444); err != nil {
+		return "", 0, err
+	}
+
+	name := filepath.Join(dir, "pack-"+hex.EncodeToString(checksum))
+	if checkFileExists(name+".pack") && checkFileExists(name+".idx") {
+		return name + ".pack", deltaCount, nil
+	}
+	// the index goes last, since readers only look for packs with one
+	err = renameSynced(fo, name+".pack")
+	if err != nil {
+		return "", 0, err
+	}
+	err = writePackIndex(name+".idx", objects, offsets, crcs, checksum)
+	if err != nil {
+		os.Remove(name + ".pack")
+		return "", 0, err
+	}
+	return name + ".pack", deltaCount, nil
+}
+
+func writePackIndex(path string, objects []PackObject, offsets []uint64, crcs []uint32, checksum []byte) error {
+	order := make([]int, len(objects))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return objects[order[a]].Oid < objects[order[b]].Oid })
+
+	buf := append([]byte{}, idxMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, 2)
+	fanout := [256]uint32{}
+	for _, object := range objects {
+		first, err := hex.DecodeString(object.Oid[:2])
+		if err != nil {
+			return err
+		}
+		for b := int(first[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	for _, count := range fanout {
+		buf = binary.BigEndian.AppendUint32(buf, count)
+	}
+	for _, i := range order {
+		oid, err := hex.DecodeString(objects[i].Oid)
+		if err != nil || len(oid) != 20 {
+			return errors.New(fmt.Sprintf("invalid oid '%s'", objects[i].Oid))
+		}
+		buf = append(buf, oid...)
+	}
+	for _, i := range order {
+		buf = binary.BigEndian.AppendUint32(buf, crcs[i])
+	}
+	large := []uint64{}
+	for _, i := range order {
+		if offsets[i] < 0x80000000 {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(offsets[i]))
+		} else {
+			buf = binary.BigEndian.AppendUint32(buf, 0x80000000|uint32(len(large)))
+			large = append(large, offsets[i])
+		}
+	}
+	for _, offset := range large {
+		buf = binary.BigEndian.AppendUint64(buf, offset)
+	}
+	buf = append(buf, checksum...)
+	sum := sha1.Sum(buf)
+	buf = append(buf, sum[:]...)
+	fo, err := os.CreateTemp(filepath.Dir(path), "tmp_idx_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fo.Name()) // a no-op once renamed into place
+	defer fo.Close()
+	if _, err := fo.Write(buf); err != nil {
+		return err
+	}
+	if err := fo.Chmod(0444); err != nil {
+		return err
+	}
+	return renameSynced(fo, path)
+}
@@ -0,0 +1,310 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testOid(type_ string, content []byte) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(objectHeader(type_, int64(len(content)))))
+	hasher.Write(content)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// packEntry is an object as it is written in a pack: its header, any base
+// reference, and the deflated content or delta.
+type packEntry struct {
+	oid  string
+	data []byte
+}
+
+// writeTestPack writes entries as a pack with its index in a temporary
+// directory, and opens it.
+func writeTestPack(t *testing.T, entries []packEntry) *Pack {
+	t.Helper()
+	dir := t.TempDir()
+	buf := append([]byte("PACK"), 0, 0, 0, 2)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entries)))
+	objects := make([]PackObject, len(entries))
+	offsets := make([]uint64, len(entries))
+	crcs := make([]uint32, len(entries))
+	for i, entry := range entries {
+		objects[i] = PackObject{Oid: entry.oid}
+		offsets[i] = uint64(len(buf))
+		crcs[i] = crc32.ChecksumIEEE(entry.data)
+		buf = append(buf, entry.data...)
+	}
+	checksum := sha1.Sum(buf)
+	buf = append(buf, checksum[:]...)
+	path := filepath.Join(dir, "pack-test.pack")
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePackIndex(filepath.Join(dir, "pack-test.idx"), objects, offsets, crcs, checksum[:]); err != nil {
+		t.Fatal(err)
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+func wholeEntry(type_ int, content []byte) []byte {
+	return append(appendPackHeader(nil, type_, len(content)), deflate(content)...)
+}
+
+func refDeltaEntry(base string, delta []byte) []byte {
+	entry := appendPackHeader(nil, OBJ_REF_DELTA, len(delta))
+	oid, _ := hex.DecodeString(base)
+	return append(append(entry, oid...), deflate(delta)...)
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("0123456789abcdef")
+	big := bytes.Repeat([]byte("x"), 0x10000)
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+		want  string // "" for an error
+	}{
+		{"insert", base, []byte{16, 3, 3, 'a', 'b', 'c'}, "abc"},
+		{"copy", base, []byte{16, 4, 0x91, 2, 4}, "2345"},
+		{"copy from the start", base, []byte{16, 2, 0x90, 2}, "01"},
+		{"copy and insert", base, []byte{16, 6, 0x91, 10, 3, 3, 'X', 'Y', 'Z'}, "abcXYZ"},
+		{"copy of 0x10000 bytes", big, []byte{0x80, 0x80, 4, 0x80, 0x80, 4, 0x80}, string(big)},
+		{"wrong base size", base, []byte{15, 1, 1, 'a'}, ""},
+		{"truncated size", base, []byte{0x90}, ""},
+		{"insert of nothing", base, []byte{16, 0, 0}, ""},
+		{"insert past the end", base, []byte{16, 3, 5, 'a'}, ""},
+		{"truncated copy", base, []byte{16, 4, 0x91, 2}, ""},
+		{"copy out of range", base, []byte{16, 4, 0x91, 14, 4}, ""},
+		{"wrong result size", base, []byte{16, 5, 0x91, 2, 4}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := ApplyDelta(test.base, test.delta)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("applied as %q", out)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if string(out) != test.want {
+				t.Fatalf("got %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateDelta(t *testing.T) {
+	var lines bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&lines, "line %d\n", i)
+	}
+	base := lines.Bytes()
+	tests := []struct {
+		name   string
+		base   []byte
+		target []byte
+	}{
+		{"empty", []byte{}, []byte{}},
+		{"from nothing", []byte{}, []byte("new content")},
+		{"to nothing", base, []byte{}},
+		{"identical", base, base},
+		{"appended", base, append(append([]byte{}, base...), "more\n"...)},
+		{"prepended", base, append([]byte("first\n"), base...)},
+		{"edited in the middle", base, bytes.Replace(base, []byte("line 2500\n"), []byte("changed\n"), 1)},
+		{"unrelated", base, bytes.Repeat([]byte("zyx"), 1000)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delta := CreateDelta(test.base, test.target)
+			out, err := ApplyDelta(test.base, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, test.target) {
+				t.Fatal("the delta does not rebuild the target")
+			}
+		})
+	}
+}
+
+func TestPackHeaders(t *testing.T) {
+	for _, size := range []int{0, 15, 16, 127, 2047, 2048, 1 << 20, 1<<32 + 5} {
+		for _, type_ := range []int{OBJ_BLOB, OBJ_OFS_DELTA, OBJ_REF_DELTA} {
+			r := bufio.NewReader(bytes.NewReader(appendPackHeader(nil, type_, size)))
+			gotType, gotSize, err := readPackHeader(r)
+			if err != nil || gotType != type_ || gotSize != uint64(size) {
+				t.Fatalf("type %d of size %d read back as %d of %d (%v)", type_, size, gotType, gotSize, err)
+			}
+		}
+	}
+	for _, distance := range []uint64{1, 127, 128, 16511, 16512, 2113663, 2113664, 1 << 40} {
+		r := bufio.NewReader(bytes.NewReader(appendOfsDelta(nil, distance)))
+		got, err := readOfsDelta(r)
+		if err != nil || got != distance {
+			t.Fatalf("distance %d read back as %d (%v)", distance, got, err)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Fatalf("distance %d was not read to its end", distance)
+		}
+	}
+}
+
+func TestWritePackDeltas(t *testing.T) {
+	objects := []PackObject{}
+	contents := map[string]string{}
+	var content strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&content, "version %d of a file that keeps growing\n", i)
+		oid := testOid("blob", []byte(content.String()))
+		objects = append(objects, PackObject{Oid: oid, Type: "blob", Content: []byte(content.String())})
+		contents[oid] = content.String()
+	}
+	commit := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nmessage\n")
+	objects = append(objects, PackObject{Oid: testOid("commit", commit), Type: "commit", Content: commit})
+	contents[testOid("commit", commit)] = string(commit)
+
+	path, deltaCount, err := WritePack(t.TempDir(), objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltaCount == 0 {
+		t.Fatal("nothing was stored as a delta")
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack.Oids()) != len(contents) {
+		t.Fatalf("the pack has %d objects, not %d", len(pack.Oids()), len(contents))
+	}
+	for oid, want := range contents {
+		type_, got, err := pack.ReadObject(oid, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want || (type_ != "blob" && type_ != "commit") {
+			t.Fatalf("%s reads as %s %q", oid, type_, got)
+		}
+	}
+}
+
+func TestReadRefDelta(t *testing.T) {
+	base := []byte(strings.Repeat("shared content\n", 10))
+	target := append(append([]byte{}, base...), "and a new line\n"...)
+	baseOid, targetOid := testOid("blob", base), testOid("blob", target)
+	delta := CreateDelta(base, target)
+
+	// bases outside the pack come from a store, as for a thin pack
+	store := NewMemoryStore()
+	if _, err := store.Put(bytes.NewReader(base), "blob"); err != nil {
+		t.Fatal(err)
+	}
+	external := func(oid string) (string, []byte, error) {
+		type_, r, err := store.Get(oid)
+		if err != nil {
+			return "", nil, err
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		return type_, content, err
+	}
+
+	tests := []struct {
+		name     string
+		entries  []packEntry
+		external func(oid string) (string, []byte, error)
+		wantErr  string
+	}{
+		{
+			"base in the pack",
+			[]packEntry{{baseOid, wholeEntry(OBJ_BLOB, base)}, {targetOid, refDeltaEntry(baseOid, delta)}},
+			nil, "",
+		},
+		{
+			"base after the delta",
+			[]packEntry{{targetOid, refDeltaEntry(baseOid, delta)}, {baseOid, wholeEntry(OBJ_BLOB, base)}},
+			nil, "",
+		},
+		{
+			"base in the store",
+			[]packEntry{{targetOid, refDeltaEntry(baseOid, delta)}},
+			external, "",
+		},
+		{
+			"missing base",
+			[]packEntry{{targetOid, refDeltaEntry(baseOid, delta)}},
+			nil, "not found",
+		},
+		{
+			"base not in the store",
+			[]packEntry{{targetOid, refDeltaEntry(testOid("blob", []byte("other")), delta)}},
+			external, "not found",
+		},
+		{
+			"delta against itself",
+			[]packEntry{{targetOid, refDeltaEntry(targetOid, delta)}},
+			nil, "too deep",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pack := writeTestPack(t, test.entries)
+			type_, content, err := pack.ReadObject(targetOid, test.external)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %v, want an error about %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if type_ != "blob" || !bytes.Equal(content, target) {
+				t.Fatalf("read %s %q", type_, content)
+			}
+		})
+	}
+}
+
+func TestReadOfsDelta(t *testing.T) {
+	base := []byte(strings.Repeat("shared content\n", 10))
+	target := append([]byte("a new first line\n"), base...)
+	baseOid, targetOid := testOid("blob", base), testOid("blob", target)
+	baseEntry := wholeEntry(OBJ_BLOB, base)
+	delta := CreateDelta(base, target)
+	ofsDelta := func(distance uint64) []byte {
+		entry := appendOfsDelta(appendPackHeader(nil, OBJ_OFS_DELTA, len(delta)), distance)
+		return append(entry, deflate(delta)...)
+	}
+
+	pack := writeTestPack(t, []packEntry{{baseOid, baseEntry}, {targetOid, ofsDelta(uint64(len(baseEntry)))}})
+	type_, content, err := pack.ReadObject(targetOid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if type_ != "blob" || !bytes.Equal(content, target) {
+		t.Fatalf("read %s %q", type_, content)
+	}
+
+	// a distance reaching before the start of the pack
+	pack = writeTestPack(t, []packEntry{{baseOid, baseEntry}, {targetOid, ofsDelta(1 << 20)}})
+	if _, _, err := pack.ReadObject(targetOid, nil); err == nil || !strings.Contains(err.Error(), "bad delta offset") {
+		t.Fatalf("got %v, want a bad delta offset", err)
+	}
+}
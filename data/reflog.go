@@ -0,0 +1,172 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Every update of HEAD or a branch is appended to a log under .ugit/logs,
+// one line per update in git's format:
+//
+//	<old oid> <new oid> <name> <<email>> <unix seconds> <+hhmm>\t<reason>
+//
+// An oid of all zeros means the ref did not exist (or was not an oid).
+
+const LOGS_DIR string = "logs"
+
+var zeroOid string = strings.Repeat("0", OID_LEN)
+
+// A ReflogEntry records one update of a ref.
+type ReflogEntry struct {
+	Old    string // "" when the ref did not exist before
+	New    string
+	Who    Ident
+	Reason string
+}
+
+func (repo *Repository) reflogPath(ref string) string {
+	if isSharedRef(ref) {
+		return filepath.Join(repo.CommonDir, LOGS_DIR, filepath.FromSlash(ref))
+	}
+	return filepath.Join(repo.GitDir, LOGS_DIR, filepath.FromSlash(ref))
+}
+
+// hasReflog says whether updates of ref are logged: HEAD and branches are.
+func hasReflog(ref string) bool {
+	return ref == HEAD || strings.HasPrefix(ref, HEADS_PREFIX)
+}
+
+func (repo *Repository) appendReflog(ref string, old string, new string, reason string) error {
+	if !hasReflog(ref) || new == "" {
+		return nil
+	}
+	who, err := repo.GetIdent(ROLE_COMMITTER)
+	if err != nil {
+		return err
+	}
+	if old == "" {
+		old = zeroOid
+	}
+	reason = strings.Join(strings.Fields(reason), " ") // one line
+	file := repo.reflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(file), os.FileMode(0755)); err != nil {
+		return err
+	}
+	fo, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(fo, "%s %s %s\t%s\n", old, new, who, reason)
+	if err == nil {
+		err = fo.Sync()
+	}
+	if closeErr := fo.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// logRefUpdate records that ref moved from old to new, in the log of ref and,
+// when HEAD is attached to ref, in the log of HEAD too.
+func (repo *Repository) logRefUpdate(ref string, old string, new string, reason string) error {
+	if err := repo.appendReflog(ref, old, new, reason); err != nil {
+		return err
+	}
+	if ref == HEAD {
+		return nil
+	}
+	head, err := repo.readRef(HEAD)
+	if err != nil || !head.Symbolic || head.Value != ref {
+		return err
+	}
+	return repo.appendReflog(HEAD, old, new, reason)
+}
+
+func parseReflogEntry(line string) (ReflogEntry, error) {
+	invalid := errors.New(fmt.Sprintf("invalid reflog entry '%s'", line))
+	header, reason, _ := strings.Cut(line, "\t")
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 || len(fields[0]) != OID_LEN || len(fields[1]) != OID_LEN {
+		return ReflogEntry{}, invalid
+	}
+	entry := ReflogEntry{Old: fields[0], New: fields[1], Reason: reason}
+	if entry.Old == zeroOid {
+		entry.Old = ""
+	}
+	start := strings.Index(fields[2], " <")
+	end := strings.LastIndex(fields[2], "> ")
+	if start < 0 || end < start {
+		return ReflogEntry{}, invalid
+	}
+	when, err := ParseTimestamp(fields[2][end+2:])
+	if err != nil {
+		return ReflogEntry{}, err
+	}
+	entry.Who = Ident{Name: fields[2][:start], Email: fields[2][start+2 : end], When: when}
+	return entry, nil
+}
+
+// ReadReflog returns the recorded updates of ref, oldest first. A ref that
+// was never logged has none.
+func (repo *Repository) ReadReflog(ref string) ([]ReflogEntry, error) {
+	fh, err := os.Open(repo.reflogPath(ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	entries := []ReflogEntry{}
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := parseReflogEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// IterReflogs lists the refs that have a log: HEAD first, then the branches.
+func (repo *Repository) IterReflogs() ([]string, error) {
+	refs := []string{}
+	if checkFileExists(repo.reflogPath(HEAD)) {
+		refs = append(refs, HEAD)
+	}
+	root := filepath.Join(repo.CommonDir, LOGS_DIR)
+	err := filepath.WalkDir(repo.reflogPath("refs"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, filepath.ToSlash(rel))
+		return nil
+	})
+	return refs, err
+}
+
+func (repo *Repository) deleteReflog(ref string) error {
+	err := os.Remove(repo.reflogPath(ref))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
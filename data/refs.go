@@ -0,0 +1,269 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const HEAD string = "HEAD"
+const MERGE_HEAD string = "MERGE_HEAD"
+const HEADS_PREFIX string = "refs/heads/"
+const TAGS_PREFIX string = "refs/tags/"
+const DEFAULT_BRANCH string = "main"
+
+// symbolic refs may point at other symbolic refs; give up after this many hops
+const MAX_SYMREF_DEPTH int = 5
+
+// A RefValue is either an oid, or (when Symbolic) the name of another ref.
+type RefValue struct {
+	Symbolic bool
+	Value    string
+}
+
+type NamedRef struct {
+	Name  string
+	Value RefValue
+}
+
+// refPath is the file of ref. Refs under refs/ are shared by all worktrees;
+// HEAD and MERGE_HEAD belong to each.
+func (repo *Repository) refPath(ref string) string {
+	if isSharedRef(ref) {
+		return filepath.Join(repo.CommonDir, filepath.FromSlash(ref))
+	}
+	return filepath.Join(repo.GitDir, filepath.FromSlash(ref))
+}
+
+func isSharedRef(ref string) bool {
+	return ref == "refs" || strings.HasPrefix(ref, "refs/")
+}
+
+// CheckRefName applies a subset of git's check-ref-format rules to a name
+// such as a branch or tag, or a full ref under refs/.
+func CheckRefName(name string) error {
+	if name == "" {
+		return errors.New("ref name must not be empty")
+	}
+	if name == HEAD || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") ||
+		strings.HasPrefix(name, ".") || strings.Contains(name, "/.") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return errors.New(fmt.Sprintf("'%s' is not a valid ref name", name))
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return errors.New(fmt.Sprintf("'%s' is not a valid ref name", name))
+		}
+	}
+	return nil
+}
+
+func (repo *Repository) readRef(ref string) (RefValue, error) {
+	file := repo.refPath(ref)
+	if !checkFileExists(file) {
+		return RefValue{}, nil
+	}
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return RefValue{}, err
+	}
+	value := strings.TrimSpace(string(buf))
+	if strings.HasPrefix(value, "ref:") {
+		return RefValue{Symbolic: true, Value: strings.TrimSpace(strings.TrimPrefix(value, "ref:"))}, nil
+	}
+	return RefValue{Value: value}, nil
+}
+
+// resolveRef follows symbolic refs (when deref is set) and returns the name
+// of the last ref in the chain along with its value.
+func (repo *Repository) resolveRef(ref string, deref bool) (string, RefValue, error) {
+	for depth := 0; depth <= MAX_SYMREF_DEPTH; depth++ {
+		value, err := repo.readRef(ref)
+		if err != nil {
+			return "", RefValue{}, err
+		}
+		if !value.Symbolic || !deref {
+			return ref, value, nil
+		}
+		ref = value.Value
+	}
+	return "", RefValue{}, errors.New(fmt.Sprintf("too many levels of symbolic refs at %s", ref))
+}
+
+func (repo *Repository) GetRef(ref string, deref bool) (RefValue, error) {
+	_, value, err := repo.resolveRef(ref, deref)
+	return value, err
+}
+
+func (repo *Repository) RefExists(ref string) bool {
+	info, err := os.Stat(repo.refPath(ref))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// UpdateRef points ref at value. reason is recorded in the reflog.
+func (repo *Repository) UpdateRef(ref string, value RefValue, deref bool, reason string) error {
+	return repo.updateRef(ref, value, deref, nil, reason)
+}
+
+// CompareAndSwapRef is UpdateRef, but fails if the ref no longer holds old,
+// because someone else moved it since it was read. An empty old means the
+// ref must not exist yet.
+func (repo *Repository) CompareAndSwapRef(ref string, old RefValue, value RefValue, deref bool, reason string) error {
+	return repo.updateRef(ref, value, deref, &old, reason)
+}
+
+func formatRefValue(value RefValue) string {
+	if value.Value == "" {
+		return "nothing"
+	} else if value.Symbolic {
+		return "ref: " + value.Value
+	}
+	return value.Value
+}
+
+// lockRef locks the file of ref (or of the ref it points at, when deref is
+// set) and returns the name locked along with its value.
+func (repo *Repository) lockRef(ref string, deref bool) (*lockFile, string, RefValue, error) {
+	ref, _, err := repo.resolveRef(ref, deref)
+	if err != nil {
+		return nil, "", RefValue{}, err
+	}
+	file := repo.refPath(ref)
+	if err := os.MkdirAll(filepath.Dir(file), os.FileMode(0755)); err != nil {
+		return nil, "", RefValue{}, err
+	}
+	lock, err := lock(file)
+	if err != nil {
+		return nil, "", RefValue{}, err
+	}
+	// read again now that nobody else can change it
+	current, err := repo.readRef(ref)
+	if err != nil {
+		lock.rollback()
+		return nil, "", RefValue{}, err
+	}
+	return lock, ref, current, nil
+}
+
+// oidOf is the oid value stands for, following it if it is symbolic.
+func (repo *Repository) oidOf(value RefValue) (string, error) {
+	if !value.Symbolic {
+		return value.Value, nil
+	}
+	value, err := repo.GetRef(value.Value, true)
+	return value.Value, err
+}
+
+func (repo *Repository) updateRef(ref string, value RefValue, deref bool, old *RefValue, reason string) error {
+	if err := repo.checkInitialized(); err != nil {
+		return err
+	}
+	if value.Value == "" {
+		return errors.New(fmt.Sprintf("UpdateRef failed: empty value for %s", ref))
+	}
+	lock, ref, current, err := repo.lockRef(ref, deref)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	if old != nil && current != *old {
+		return errors.New(fmt.Sprintf("cannot update %s: expected %s but found %s; it was changed by someone else", ref, formatRefValue(*old), formatRefValue(current)))
+	}
+	if _, err := lock.WriteString(formatRefValue(value) + "\n"); err != nil {
+		return err
+	}
+	oldOid, err := repo.oidOf(current)
+	if err != nil {
+		return err
+	}
+	newOid, err := repo.oidOf(value)
+	if err != nil {
+		return err
+	}
+	if err := lock.commit(); err != nil {
+		return err
+	}
+	return repo.logRefUpdate(ref, oldOid, newOid, reason)
+}
+
+func (repo *Repository) DeleteRef(ref string, deref bool) error {
+	if err := repo.checkInitialized(); err != nil {
+		return err
+	}
+	lock, ref, _, err := repo.lockRef(ref, deref)
+	if err != nil {
+		return err
+	}
+	defer lock.rollback()
+	if err := os.Remove(repo.refPath(ref)); err != nil {
+		return err
+	}
+	if err := repo.deleteReflog(ref); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(repo.refPath(ref)))
+}
+
+// IterRefs lists HEAD and everything under refs/ whose name starts with
+// prefix, sorted by name.
+func (repo *Repository) IterRefs(prefix string, deref bool) ([]NamedRef, error) {
+	names := []string{HEAD}
+	root := repo.refPath("refs")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), LOCK_SUFFIX) {
+			return nil
+		}
+		rel, err := filepath.Rel(repo.CommonDir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names[1:])
+
+	refs := []NamedRef{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || !repo.RefExists(name) {
+			continue
+		}
+		value, err := repo.GetRef(name, deref)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, NamedRef{Name: name, Value: value})
+	}
+	return refs, nil
+}
+
+// GetHead returns the commit HEAD points at, or "" on an unborn branch.
+func (repo *Repository) GetHead() (oid string, err error) {
+	value, err := repo.GetRef(HEAD, true)
+	return value.Value, err
+}
+
+// SetHead moves HEAD to oid. When HEAD is attached to a branch, the branch is
+// advanced instead.
+func (repo *Repository) SetHead(oid string, reason string) error {
+	return repo.UpdateRef(HEAD, RefValue{Value: oid}, true, reason)
+}
+
+// CompareAndSwapHead is SetHead, but fails unless HEAD still resolves to old
+// ("" on an unborn branch).
+func (repo *Repository) CompareAndSwapHead(old string, oid string, reason string) error {
+	return repo.CompareAndSwapRef(HEAD, RefValue{Value: old}, RefValue{Value: oid}, true, reason)
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// UGIT_DIR_ENV names the git dir to use instead of searching for one, and
+// UGIT_WORK_TREE_ENV the worktree that goes with it.
+const UGIT_DIR_ENV string = "UGIT_DIR"
+const UGIT_WORK_TREE_ENV string = "UGIT_WORK_TREE"
+
+// A Repository is a git dir, which holds the objects, refs, index and config,
+// together with the worktree checked out from it. Paths are used as given,
+// so relative ones depend on the current directory.
+//
+// A linked worktree (see AddWorktree) has a git dir of its own with just its
+// HEAD, MERGE_HEAD, index and HEAD reflog; everything else is kept in the
+// common dir of the repository it was added to.
+type Repository struct {
+	GitDir    string
+	CommonDir string // GitDir itself, except in linked worktrees
+	WorkTree  string // "" in a bare repository
+
+	packsLock sync.Mutex
+	packs     map[string]*Pack // already opened, by the path of their index
+}
+
+// NewRepository returns the repository checked out in workTree, whose git
+// dir is the GIT_DIR inside it.
+func NewRepository(workTree string) *Repository {
+	return OpenRepository(filepath.Join(workTree, GIT_DIR), workTree)
+}
+
+// OpenRepository returns the repository with the given git dir and worktree.
+func OpenRepository(gitDir string, workTree string) *Repository {
+	commonDir := gitDir
+	if buf, err := os.ReadFile(filepath.Join(gitDir, COMMONDIR_FILE)); err == nil {
+		commonDir = strings.TrimSpace(string(buf))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return &Repository{GitDir: gitDir, CommonDir: commonDir, WorkTree: workTree, packs: map[string]*Pack{}}
+}
+
+func (repo *Repository) objectsDir() string {
+	return filepath.Join(repo.CommonDir, "objects")
+}
+
+// IsBare says whether the repository has no worktree.
+func (repo *Repository) IsBare() bool {
+	return repo.WorkTree == ""
+}
+
+// CheckWorkTree fails in a bare repository.
+func (repo *Repository) CheckWorkTree() error {
+	if repo.IsBare() {
+		return errors.New(fmt.Sprintf("this operation must be run in a worktree; %s is a bare repository", repo.GitDir))
+	}
+	return nil
+}
+
+// isGitDir says whether dir is itself a git dir, as a bare repository is.
+func isGitDir(dir string) bool {
+	for _, sub := range []string{"objects", "refs"} {
+		info, err := os.Stat(filepath.Join(dir, sub))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return checkFileExists(filepath.Join(dir, HEAD))
+}
+
+// isBareGitDir says whether the config in gitDir has core.bare set.
+func isBareGitDir(gitDir string) bool {
+	value, _, err := lookupConfig(filepath.Join(gitDir, "config"), "core", "bare")
+	return err == nil && value == "true"
+}
+
+// FindRepository returns the repository dir belongs to, with absolute paths.
+// UGIT_DIR and UGIT_WORK_TREE are honored first; a UGIT_DIR without a
+// UGIT_WORK_TREE is checked out in dir, unless it is bare. Otherwise, when
+// search is set, the closest GIT_DIR in dir or one of its parents is used,
+// or the closest of them that is a git dir itself, which has no worktree.
+// It is an error if there is none. Without search, the repository is the
+// one in dir, whether it exists yet or not.
+func FindRepository(dir string, search bool) (*Repository, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	gitDir, workTree := os.Getenv(UGIT_DIR_ENV), os.Getenv(UGIT_WORK_TREE_ENV)
+	if gitDir != "" {
+		if gitDir, err = filepath.Abs(gitDir); err != nil {
+			return nil, err
+		}
+		if workTree == "" && !isBareGitDir(gitDir) {
+			workTree = dir
+		}
+	} else {
+		top := dir
+		for search {
+			if info, err := os.Stat(filepath.Join(top, GIT_DIR)); err == nil && info.IsDir() {
+				break
+			} else if err == nil {
+				// a linked worktree, whose git dir is elsewhere
+				if gitDir, err = readGitFile(filepath.Join(top, GIT_DIR)); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if isGitDir(top) {
+				gitDir = top
+				break
+			}
+			parent := filepath.Dir(top)
+			if parent == top {
+				return nil, errors.New(fmt.Sprintf("not a ugit repository (or any of the parent directories): %s", GIT_DIR))
+			}
+			top = parent
+		}
+		if gitDir == "" {
+			gitDir = filepath.Join(top, GIT_DIR)
+		}
+		if workTree == "" && gitDir != top {
+			workTree = top
+		}
+	}
+	if workTree != "" {
+		if workTree, err = filepath.Abs(workTree); err != nil {
+			return nil, err
+		}
+	}
+	return OpenRepository(gitDir, workTree), nil
+}
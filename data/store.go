@@ -0,0 +1,154 @@
+package data
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// An ObjectStore keeps objects by oid. FileStore is the repository's object
+// directory; MemoryStore keeps everything in memory.
+type ObjectStore interface {
+	// Put stores content as an object of type_ and returns its oid.
+	Put(content io.Reader, type_ string) (oid string, err error)
+	// Hash returns the oid content would be stored under, without storing
+	// it.
+	Hash(content io.Reader, type_ string) (oid string, err error)
+	// Get opens an object and says what type it is.
+	Get(oid string) (type_ string, content io.ReadCloser, err error)
+	Has(oid string) bool
+	// Iterate calls fn with every stored oid, in order, stopping at the
+	// first error.
+	Iterate(fn func(oid string) error) error
+}
+
+// FileStore keeps the objects of a repository, loose or packed.
+type FileStore struct {
+	repo *Repository
+}
+
+func NewFileStore(repo *Repository) FileStore {
+	return FileStore{repo: repo}
+}
+
+func (store FileStore) Put(content io.Reader, type_ string) (oid string, err error) {
+	return store.repo.HashObject(content, type_)
+}
+
+func (store FileStore) Hash(content io.Reader, type_ string) (oid string, err error) {
+	return store.repo.ComputeOid(content, type_)
+}
+
+func (store FileStore) Get(oid string) (type_ string, content io.ReadCloser, err error) {
+	r, type_, err := store.repo.openObject(oid)
+	if err != nil {
+		return "", nil, err
+	}
+	return type_, r, nil
+}
+
+func (store FileStore) Has(oid string) bool {
+	return store.repo.ObjectExists(oid)
+}
+
+func (store FileStore) Iterate(fn func(oid string) error) error {
+	oids, err := store.repo.listObjects()
+	if err != nil {
+		return err
+	}
+	for _, oid := range oids {
+		if err := fn(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryObject struct {
+	type_   string
+	content []byte
+}
+
+// MemoryStore keeps objects in a map, so nothing touches the disk. Oids are
+// computed as in repository format version 1.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string]memoryObject{}}
+}
+
+// hash reads content and returns it with its oid.
+func (store *MemoryStore) hash(content io.Reader, type_ string) (oid string, buf []byte, err error) {
+	buf, err = io.ReadAll(content)
+	if err != nil {
+		return "", nil, err
+	}
+	hasher := sha1.New()
+	hasher.Write([]byte(objectHeader(type_, int64(len(buf)))))
+	hasher.Write(buf)
+	return hex.EncodeToString(hasher.Sum(nil)), buf, nil
+}
+
+func (store *MemoryStore) Put(content io.Reader, type_ string) (oid string, err error) {
+	if type_ == "" {
+		type_ = "blob"
+	}
+	oid, buf, err := store.hash(content, type_)
+	if err != nil {
+		return "", err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.objects[oid] = memoryObject{type_: type_, content: buf}
+	return oid, nil
+}
+
+func (store *MemoryStore) Hash(content io.Reader, type_ string) (oid string, err error) {
+	if type_ == "" {
+		type_ = "blob"
+	}
+	oid, _, err = store.hash(content, type_)
+	return oid, err
+}
+
+func (store *MemoryStore) Get(oid string) (type_ string, content io.ReadCloser, err error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	object, ok := store.objects[oid]
+	if !ok {
+		return "", nil, errors.New(fmt.Sprintf("object %s not found", oid))
+	}
+	return object.type_, io.NopCloser(bytes.NewReader(object.content)), nil
+}
+
+func (store *MemoryStore) Has(oid string) bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	_, ok := store.objects[oid]
+	return ok
+}
+
+func (store *MemoryStore) Iterate(fn func(oid string) error) error {
+	store.lock.RLock()
+	oids := make([]string, 0, len(store.objects))
+	for oid := range store.objects {
+		oids = append(oids, oid)
+	}
+	store.lock.RUnlock() // fn may Put
+	sort.Strings(oids)
+	for _, oid := range oids {
+		if err := fn(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Linked worktrees are kept under worktrees/<name> in the common dir, each
+// with a commondir file pointing back at the common dir and a gitdir file
+// naming the GIT_DIR file in the worktree. That file in turn holds
+//
+//	gitdir: <common dir>/worktrees/<name>
+//
+// so that the worktree can find its git dir.
+
+const WORKTREES_DIR string = "worktrees"
+const COMMONDIR_FILE string = "commondir"
+const GITDIR_FILE string = "gitdir"
+
+// A Worktree is one of the worktrees checked out from a repository.
+type Worktree struct {
+	Name   string // "" for the main worktree
+	Path   string // "" for the main worktree of a bare repository
+	GitDir string
+}
+
+func (worktree Worktree) IsMain() bool {
+	return worktree.Name == ""
+}
+
+// readGitFile returns the git dir a GIT_DIR file points at.
+func readGitFile(file string) (string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	value, found := strings.CutPrefix(strings.TrimSpace(string(buf)), "gitdir:")
+	if !found {
+		return "", errors.New(fmt.Sprintf("invalid git file %s", file))
+	}
+	gitDir := strings.TrimSpace(value)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(file), gitDir)
+	}
+	return gitDir, nil
+}
+
+// mainWorktree is the worktree the common dir belongs to.
+func (repo *Repository) mainWorktree() Worktree {
+	main := Worktree{GitDir: repo.CommonDir}
+	if repo.GitDir == repo.CommonDir {
+		main.Path = repo.WorkTree
+	} else if !isBareGitDir(repo.CommonDir) && filepath.Base(repo.CommonDir) == GIT_DIR {
+		main.Path = filepath.Dir(repo.CommonDir)
+	}
+	return main
+}
+
+// ListWorktrees returns the main worktree followed by the linked ones, by
+// name.
+func (repo *Repository) ListWorktrees() ([]Worktree, error) {
+	worktrees := []Worktree{repo.mainWorktree()}
+	entries, err := os.ReadDir(filepath.Join(repo.CommonDir, WORKTREES_DIR))
+	if errors.Is(err, os.ErrNotExist) {
+		return worktrees, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		gitDir := filepath.Join(repo.CommonDir, WORKTREES_DIR, entry.Name())
+		buf, err := os.ReadFile(filepath.Join(gitDir, GITDIR_FILE))
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Dir(strings.TrimSpace(string(buf)))
+		worktrees = append(worktrees, Worktree{Name: entry.Name(), Path: path, GitDir: gitDir})
+	}
+	return worktrees, nil
+}
+
+// OpenWorktree returns the repository as checked out in worktree.
+func (repo *Repository) OpenWorktree(worktree Worktree) *Repository {
+	return OpenRepository(worktree.GitDir, worktree.Path)
+}
+
+// AddWorktree links a new worktree at path, which must not exist or be an
+// empty directory, and returns it with nothing checked out and no HEAD yet.
+// It is named after the last element of path.
+func (repo *Repository) AddWorktree(path string) (*Repository, error) {
+	if err := repo.checkInitialized(); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+		return nil, errors.New(fmt.Sprintf("'%s' already exists", path))
+	}
+	base := filepath.Join(repo.CommonDir, WORKTREES_DIR, filepath.Base(path))
+	gitDir := base
+	for n := 1; checkFileExists(gitDir); n++ {
+		gitDir = base + strconv.Itoa(n)
+	}
+	if err := os.MkdirAll(gitDir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	commonDir, err := filepath.Rel(gitDir, repo.CommonDir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		filepath.Join(gitDir, COMMONDIR_FILE): commonDir,
+		filepath.Join(gitDir, GITDIR_FILE):    filepath.Join(path, GIT_DIR),
+		filepath.Join(path, GIT_DIR):          "gitdir: " + gitDir,
+	}
+	for file, content := range files {
+		if err := writeFileLocked(file, []byte(content+"\n")); err != nil {
+			return nil, err
+		}
+	}
+	return OpenRepository(gitDir, path), nil
+}
+
+// RemoveWorktree deletes a linked worktree, files and all.
+func (repo *Repository) RemoveWorktree(worktree Worktree) error {
+	if worktree.IsMain() {
+		return errors.New("the main worktree cannot be removed")
+	}
+	if err := os.RemoveAll(worktree.Path); err != nil {
+		return err
+	}
+	return os.RemoveAll(worktree.GitDir)
+}
@@ -5,17 +5,87 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
 
 	"log"
 	"os"
+	"time"
 
 	"jerroyd.com/ugit/base"
 	"jerroyd.com/ugit/data"
 )
 
-func initialize() error {
-	return data.Initialize()
+// the repository the current directory belongs to
+var repo *base.Repository
+
+// repoPaths turns paths given relative to the current directory into paths
+// relative to the worktree.
+func repoPaths(paths []string) ([]string, error) {
+	if err := repo.CheckWorkTree(); err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(paths))
+	for i, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		rel, err := filepath.Rel(repo.WorkTree, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, errors.New(fmt.Sprintf("'%s' is outside the worktree at %s", paths[i], repo.WorkTree))
+		}
+		result[i] = filepath.ToSlash(rel)
+	}
+	return result, nil
+}
+
+// displayPath turns a path relative to the worktree into one relative to the
+// current directory, keeping the trailing "/" of a directory.
+func displayPath(path string) string {
+	full := filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+	cwd, err := os.Getwd()
+	if err == nil {
+		if rel, err := filepath.Rel(cwd, full); err == nil {
+			full = rel
+		}
+	}
+	if strings.HasSuffix(path, "/") {
+		full += string(filepath.Separator)
+	}
+	return full
+}
+
+// initialize creates a repository in the directory given, by default the
+// current one. A bare repository is laid out in the directory itself.
+func initialize(args []string, objectFormat string, bare bool) error {
+	if len(args) > 1 {
+		return errors.New("usage: init [-bare] [-object-format format] [directory]")
+	}
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	if bare {
+		gitDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		repo = base.NewRepository(data.OpenRepository(gitDir, ""))
+	} else if len(args) == 1 {
+		if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+			return err
+		}
+		dataRepo, err := data.FindRepository(dir, false)
+		if err != nil {
+			return err
+		}
+		repo = base.NewRepository(dataRepo)
+	}
+	return repo.Initialize(objectFormat)
 }
 func hashObject(file string) error {
 	if file == "" {
@@ -26,7 +96,7 @@ func hashObject(file string) error {
 		return err
 	}
 	defer fh.Close()
-	sha, err := data.HashObject(fh, "blob")
+	sha, err := repo.HashObject(fh, "blob")
 	fmt.Printf("%s %s\n", sha, file)
 	return err
 }
@@ -34,7 +104,11 @@ func catFile(object string) error {
 	if object == "" {
 		return errors.New("must specify a -object")
 	}
-	fh, err := data.GetObject(object, "blob")
+	oid, err := repo.RevParse(object)
+	if err != nil {
+		return err
+	}
+	fh, err := repo.GetObject(oid, "blob")
 	if err != nil {
 		return err
 	}
@@ -48,30 +122,211 @@ func catFile(object string) error {
 		if n == 0 {
 			break
 		}
-		fmt.Print(string(buf))
+		fmt.Print(string(buf[:n]))
+	}
+	return nil
+}
+
+func revParse(args []string) error {
+	if len(args) == 0 {
+		return errors.New("must specify a revision")
+	}
+	for _, arg := range args {
+		oid, err := repo.RevParse(arg)
+		if err != nil {
+			return err
+		}
+		fmt.Println(oid)
+	}
+	return nil
+}
+
+func migrate() error {
+	compressed, moved, err := repo.MigrateObjects()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compressed %d objects, moved %d into fan-out directories\n", compressed, moved)
+	return nil
+}
+
+func repack() error {
+	count, deltaCount, err := repo.Repack(nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("packed %d objects (%d deltas)\n", count, deltaCount)
+	return nil
+}
+
+func gc(prune string, repack bool) error {
+	expire := base.DEFAULT_PRUNE_EXPIRE
+	if prune == "now" {
+		expire = 0
+	} else if prune != "" {
+		var err error
+		expire, err = time.ParseDuration(prune)
+		if err != nil {
+			return err
+		}
+	}
+	result, err := repo.GC(expire, repack)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d reachable objects, pruned %d\n", result.Reachable, result.Pruned)
+	if repack {
+		fmt.Printf("packed %d objects (%d deltas)\n", result.Packed, result.Deltas)
+	}
+	return nil
+}
+
+func fsck() error {
+	result, err := repo.Fsck()
+	for _, problem := range result.Problems {
+		fmt.Println(problem)
+	}
+	for _, dangling := range result.Dangling {
+		fmt.Printf("dangling %s\n", dangling)
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Problems) > 0 {
+		return errors.New(fmt.Sprintf("checked %d objects, found %d problems", result.Checked, len(result.Problems)))
+	}
+	fmt.Printf("checked %d objects\n", result.Checked)
+	return nil
+}
+
+func reflog(args []string) error {
+	name := data.HEAD
+	if len(args) > 1 {
+		return errors.New("expected at most one ref")
+	} else if len(args) == 1 {
+		name = args[0]
+	}
+	ref, err := repo.ReflogRef(name)
+	if err != nil {
+		return err
+	}
+	entries, err := repo.ReadReflog(ref)
+	if err != nil {
+		return err
+	}
+	for n := 0; n < len(entries); n++ {
+		entry := entries[len(entries)-1-n]
+		fmt.Printf("%s %s@{%d}: %s\n", entry.New, name, n, entry.Reason)
 	}
 	return nil
 }
 
 func writeTree() error {
-	oid, err := base.WriteTree(".")
+	oid, err := repo.WriteTree("")
+	if err != nil {
+		return err
+	}
 	fmt.Println(oid)
-	return err
+	return nil
 }
 
 func readTree(tree string) (err error) {
 	if tree == "" {
 		return errors.New("must specify a -tree")
 	}
-	err = base.ReadTree(tree)
+	oid, err := repo.GetTreeOid(tree)
+	if err != nil {
+		return err
+	}
+	err = repo.ReadTree(oid)
 	return err
 }
 
+func add(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("must specify the paths to add")
+	}
+	paths, err := repoPaths(paths)
+	if err != nil {
+		return err
+	}
+	return repo.Add(paths)
+}
+
+func rm(paths []string, cached bool, force bool) error {
+	if len(paths) == 0 {
+		return errors.New("must specify the paths to remove")
+	}
+	paths, err := repoPaths(paths)
+	if err != nil {
+		return err
+	}
+	return repo.Remove(paths, cached, force)
+}
+
+func printChanges(changes base.Changes) {
+	for _, path := range changes.Added {
+		fmt.Printf("\tnew file:   %s\n", displayPath(path))
+	}
+	for _, path := range changes.Modified {
+		fmt.Printf("\tmodified:   %s\n", displayPath(path))
+	}
+	for _, path := range changes.Deleted {
+		fmt.Printf("\tdeleted:    %s\n", displayPath(path))
+	}
+	fmt.Println()
+}
+
+func status() error {
+	st, err := repo.GetStatus()
+	if err != nil {
+		return err
+	}
+	if st.Branch != "" {
+		fmt.Printf("On branch %s\n", st.Branch)
+	} else {
+		fmt.Printf("HEAD detached at %s\n", st.Head)
+	}
+	if st.Head == "" {
+		fmt.Println("\nNo commits yet")
+	}
+	if st.MergeHead != "" {
+		fmt.Printf("You are merging %s; commit to conclude the merge.\n", st.MergeHead)
+	}
+	fmt.Println()
+	if len(st.Unmerged) > 0 {
+		fmt.Println("Unmerged paths:")
+		for _, path := range st.Unmerged {
+			fmt.Printf("\tunmerged:   %s\n", displayPath(path))
+		}
+		fmt.Println()
+	}
+	if !st.Staged.IsEmpty() {
+		fmt.Println("Changes to be committed:")
+		printChanges(st.Staged)
+	}
+	if !st.Unstaged.IsEmpty() {
+		fmt.Println("Changes not staged for commit:")
+		printChanges(st.Unstaged)
+	}
+	if len(st.Untracked) > 0 {
+		fmt.Println("Untracked files:")
+		for _, path := range st.Untracked {
+			fmt.Printf("\t%s\n", displayPath(path))
+		}
+		fmt.Println()
+	}
+	if st.Staged.IsEmpty() && st.Unstaged.IsEmpty() && len(st.Untracked) == 0 {
+		fmt.Println("nothing to commit, working tree clean")
+	}
+	return nil
+}
+
 func commit(commitMsg string) error {
 	if commitMsg == "" {
 		return errors.New("must specify a -message")
 	}
-	oid, err := base.Commit(strings.TrimSpace(commitMsg))
+	oid, err := repo.Commit(strings.TrimSpace(commitMsg))
 	if err != nil {
 		return err
 	}
@@ -81,28 +336,362 @@ func commit(commitMsg string) error {
 
 func printLog(oid string) (err error) {
 	if oid == "" {
-		oid, err = data.GetHead()
+		oid, err = repo.GetHead()
+	} else {
+		oid, err = repo.GetCommitOid(oid)
 	}
 	if err != nil {
 		return err
 	}
+	oids, err := repo.IterCommitsAndParents([]string{oid})
+	if err != nil {
+		return err
+	}
+	for _, oid := range oids {
+		commit, err := repo.GetCommit(oid)
+		if err != nil {
+			return err
+		}
+		printCommit(oid, &commit)
+	}
+	return nil
+}
+
+func printCommit(oid string, commit *base.CommitInfo) {
 	indented := strings.Repeat(" ", 5)
-	for oid != "" {
-		commit, err := base.GetCommit(oid)
+	fmt.Printf("commit %s\n", oid)
+	if len(commit.GetParents()) > 1 {
+		fmt.Printf("Merge: %s\n", strings.Join(commit.GetParents(), " "))
+	}
+	if author := commit.GetAuthor(); author != nil {
+		fmt.Printf("Author: %s <%s>\n", author.GetName(), author.GetEmail())
+		fmt.Printf("Date:   %s\n", author.Time().Format(DATE_FORMAT))
+	}
+	if committer := commit.GetCommitter(); committer != nil &&
+		(committer.GetName() != commit.GetAuthor().GetName() || committer.GetEmail() != commit.GetAuthor().GetEmail()) {
+		fmt.Printf("Commit: %s <%s>\n", committer.GetName(), committer.GetEmail())
+	}
+	fmt.Println()
+	lines := strings.Split(strings.TrimSuffix(commit.GetMessage(), "\n"), "\n")
+	for _, line := range lines {
+		fmt.Printf("%s%s\n", indented, line)
+	}
+	fmt.Println()
+}
+
+func config(args []string, global bool) error {
+	switch len(args) {
+	case 1:
+		value, err := repo.GetConfig(args[0])
 		if err != nil {
 			return err
 		}
-		fmt.Printf("commit %s\n\n", oid)
-		lines := strings.Split(commit.GetMessage(), "\n")
-		for _, line := range lines {
-			fmt.Printf("%s%s\n", indented, line)
+		if value == "" {
+			return errors.New(fmt.Sprintf("%s is not set", args[0]))
 		}
-		fmt.Println()
-		oid = commit.GetParent()
+		fmt.Println(value)
+		return nil
+	case 2:
+		return repo.SetConfig(args[0], args[1], global)
+	}
+	return errors.New("usage: config [-global] <key> [value]")
+}
+
+func diff(args []string, cached bool) error {
+	if cached {
+		if len(args) > 1 {
+			return errors.New("usage: diff -cached [commit]")
+		}
+		from := ""
+		if len(args) == 1 {
+			from = args[0]
+		} else {
+			head, err := repo.GetHead()
+			if err != nil {
+				return err
+			}
+			from = head
+		}
+		return repo.DiffCached(os.Stdout, from)
+	}
+	switch len(args) {
+	case 0:
+		return repo.DiffIndex(os.Stdout)
+	case 1:
+		return repo.DiffWorkingTree(os.Stdout, args[0])
+	case 2:
+		return repo.DiffTrees(os.Stdout, args[0], args[1])
+	}
+	return errors.New("usage: diff [-cached] [commit [commit]]")
+}
+
+func show(args []string) (err error) {
+	if len(args) > 1 {
+		return errors.New("usage: show [commit]")
+	}
+	oid := ""
+	if len(args) == 1 {
+		oid, err = repo.RevParse(args[0])
+	} else {
+		oid, err = repo.GetHead()
+	}
+	if err != nil {
+		return err
+	}
+	if type_, err := repo.GetObjectType(oid); err == nil && type_ == "tag" {
+		tag, err := repo.GetTag(oid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("tag %s\n", tag.GetTag())
+		fmt.Printf("Tagger: %s <%s>\n", tag.GetTagger().GetName(), tag.GetTagger().GetEmail())
+		fmt.Printf("Date:   %s\n\n", tag.GetTagger().Time().Format(DATE_FORMAT))
+		fmt.Printf("%s\n\n", strings.TrimSuffix(tag.GetMessage(), "\n"))
+	}
+	oid, err = repo.Peel(oid, "commit")
+	if err != nil {
+		return err
+	}
+	commit, err := repo.GetCommit(oid)
+	if err != nil {
+		return err
+	}
+	printCommit(oid, &commit)
+	return repo.DiffCommit(os.Stdout, oid)
+}
+
+func checkout(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: checkout <branch|oid>")
+	}
+	err := repo.Checkout(args[0])
+	if err != nil {
+		return err
+	}
+	if repo.IsBranch(args[0]) {
+		fmt.Printf("Switched to branch '%s'\n", args[0])
+	} else {
+		fmt.Printf("HEAD is now at %s\n", args[0])
+	}
+	return nil
+}
+
+func merge(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: merge <branch|oid>")
+	}
+	result, err := repo.Merge(args[0])
+	if err != nil {
+		return err
+	}
+	if result.UpToDate {
+		fmt.Println("Already up to date.")
+	} else if result.FastForward {
+		fmt.Println("Fast-forward")
+	} else if len(result.Conflicts) > 0 {
+		for _, path := range result.Conflicts {
+			fmt.Printf("CONFLICT: Merge conflict in %s\n", displayPath(path))
+		}
+		fmt.Println("Automatic merge failed; fix conflicts, add them and then commit the result.")
+	} else {
+		fmt.Println("Merged in working tree. Please commit.")
+	}
+	return nil
+}
+
+func importGit(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: import-git <path>")
+	}
+	result, err := repo.ImportGit(args[0])
+	for _, skipped := range result.Skipped {
+		fmt.Printf("skipped %s\n", skipped)
+	}
+	if err != nil {
+		return err
+	}
+	for _, ref := range result.Refs {
+		fmt.Printf("%s %s\n", ref.Value.Value, ref.Name)
+	}
+	fmt.Printf("imported %d commits\n", result.Commits)
+	return nil
+}
+
+func fastExport(args []string) error {
+	return repo.FastExport(os.Stdout, args)
+}
+
+func fastImport() error {
+	result, err := repo.FastImport(os.Stdin)
+	if err != nil {
+		return err
+	}
+	for _, ref := range result.Refs {
+		fmt.Printf("%s %s\n", ref.Value.Value, ref.Name)
+	}
+	fmt.Printf("imported %d blobs, %d commits, %d tags\n", result.Blobs, result.Commits, result.Tags)
+	return nil
+}
+
+func branch(args []string, deleteBranch bool) error {
+	if deleteBranch {
+		if len(args) == 0 {
+			return errors.New("must specify a branch to delete")
+		}
+		for _, name := range args {
+			if err := repo.DeleteBranch(name); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted branch %s\n", name)
+		}
+		return nil
+	}
+	if len(args) == 0 {
+		return listBranches()
+	}
+	if len(args) > 2 {
+		return errors.New("usage: branch [-d] [name [start-point]]")
+	}
+	var oid string
+	var err error
+	if len(args) == 2 {
+		oid, err = repo.GetCommitOid(args[1])
+	} else {
+		oid, err = repo.GetHead()
+	}
+	if err != nil {
+		return err
+	}
+	return repo.CreateBranch(args[0], oid)
+}
+
+func tag(args []string, deleteTag bool, annotate bool, message string) error {
+	if deleteTag {
+		if len(args) == 0 {
+			return errors.New("must specify a tag to delete")
+		}
+		for _, name := range args {
+			if err := repo.DeleteTag(name); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted tag %s\n", name)
+		}
+		return nil
+	}
+	if len(args) == 0 {
+		names, err := repo.IterTagNames()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	if len(args) > 2 {
+		return errors.New("usage: tag [-d] [-a -m message] [name [target]]")
+	}
+	if annotate && message == "" {
+		return errors.New("an annotated tag needs a -m message")
+	}
+	var oid string
+	var err error
+	if len(args) == 2 {
+		oid, err = repo.RevParse(args[1])
+	} else {
+		oid, err = repo.GetHead()
+	}
+	if err != nil {
+		return err
+	}
+	return repo.CreateTag(args[0], oid, message)
+}
+
+func listBranches() error {
+	current, err := repo.GetBranchName()
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		head, err := repo.GetHead()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("* (HEAD detached at %s)\n", head)
+	}
+	names, err := repo.IterBranchNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		prefix := " "
+		if name == current {
+			prefix = "*"
+		}
+		fmt.Printf("%s %s\n", prefix, name)
+	}
+	return nil
+}
+
+func worktreeAdd(args []string, newBranch string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: worktree add [-b <new-branch>] <path> [<branch|oid>]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	info, err := repo.AddWorktree(args[0], name, newBranch)
+	if err != nil {
+		return err
+	}
+	if info.Branch != "" {
+		fmt.Printf("Preparing worktree (checking out '%s')\n", info.Branch)
+	} else {
+		fmt.Printf("Preparing worktree (detached HEAD %s)\n", info.Head)
+	}
+	return nil
+}
+
+func worktreeList() error {
+	worktrees, err := repo.ListWorktrees()
+	if err != nil {
+		return err
+	}
+	width := 0
+	for _, worktree := range worktrees {
+		if len(worktree.Path) > width {
+			width = len(worktree.Path)
+		}
+	}
+	for _, worktree := range worktrees {
+		if worktree.Path == "" {
+			fmt.Printf("%-*s (bare)\n", width, worktree.GitDir)
+			continue
+		}
+		line := fmt.Sprintf("%-*s", width, worktree.Path)
+		if worktree.Head != "" {
+			line += " " + worktree.Head
+		}
+		if worktree.Branch != "" {
+			line += fmt.Sprintf(" [%s]", worktree.Branch)
+		} else {
+			line += " (detached HEAD)"
+		}
+		fmt.Println(line)
 	}
 	return nil
 }
 
+func worktreeRemove(args []string, force bool) error {
+	if len(args) != 1 {
+		return errors.New("usage: worktree remove [-f] <path>")
+	}
+	return repo.RemoveWorktree(args[0], force)
+}
+
+const DATE_FORMAT string = "Mon Jan 2 15:04:05 2006 -0700"
+
 const CMD_INIT string = "init"
 const CMD_HASH_OBJECT string = "hash-object"
 const CMD_CAT_FILE string = "cat-file"
@@ -110,10 +699,34 @@ const CMD_WRITE_TREE string = "write-tree"
 const CMD_READ_TREE string = "read-tree"
 const CMD_COMMIT string = "commit"
 const CMD_LOG string = "log"
+const CMD_BRANCH string = "branch"
+const CMD_CHECKOUT string = "checkout"
+const CMD_ADD string = "add"
+const CMD_RM string = "rm"
+const CMD_STATUS string = "status"
+const CMD_DIFF string = "diff"
+const CMD_SHOW string = "show"
+const CMD_MERGE string = "merge"
+const CMD_CONFIG string = "config"
+const CMD_TAG string = "tag"
+const CMD_REV_PARSE string = "rev-parse"
+const CMD_MIGRATE string = "migrate"
+const CMD_IMPORT_GIT string = "import-git"
+const CMD_FAST_EXPORT string = "fast-export"
+const CMD_FAST_IMPORT string = "fast-import"
+const CMD_REPACK string = "repack"
+const CMD_GC string = "gc"
+const CMD_FSCK string = "fsck"
+const CMD_REFLOG string = "reflog"
+const CMD_WORKTREE string = "worktree"
+const CMD_WORKTREE_ADD string = "add"
+const CMD_WORKTREE_LIST string = "list"
+const CMD_WORKTREE_REMOVE string = "remove"
 
 func main() {
-	// init has no options
-	// initCmd := flag.NewFlagSet(CMD_INIT, flag.ExitOnError)
+	initCmd := flag.NewFlagSet(CMD_INIT, flag.ExitOnError)
+	initObjectFormat := initCmd.String("object-format", data.OBJECT_FORMAT_UGIT, "Encode objects like ugit or like git")
+	initBare := initCmd.Bool("bare", false, "Create a repository without a worktree")
 	hashObjectCmd := flag.NewFlagSet(CMD_HASH_OBJECT, flag.ExitOnError)
 	hashObjectFile := hashObjectCmd.String("file", "", "The file to hash")
 
@@ -129,16 +742,76 @@ func main() {
 	LogCmd := flag.NewFlagSet(CMD_LOG, flag.ExitOnError)
 	logOid := LogCmd.String("oid", "", "The oid of the commit to get logs")
 
+	BranchCmd := flag.NewFlagSet(CMD_BRANCH, flag.ExitOnError)
+	branchDelete := BranchCmd.Bool("d", false, "Delete the named branches")
+
+	CheckoutCmd := flag.NewFlagSet(CMD_CHECKOUT, flag.ExitOnError)
+
+	AddCmd := flag.NewFlagSet(CMD_ADD, flag.ExitOnError)
+
+	RmCmd := flag.NewFlagSet(CMD_RM, flag.ExitOnError)
+	rmCached := RmCmd.Bool("cached", false, "Only remove the paths from the index")
+	rmForce := RmCmd.Bool("f", false, "Remove the paths even if they have local modifications")
+
+	DiffCmd := flag.NewFlagSet(CMD_DIFF, flag.ExitOnError)
+	diffCached := DiffCmd.Bool("cached", false, "Compare the index against a commit (HEAD by default)")
+
+	ShowCmd := flag.NewFlagSet(CMD_SHOW, flag.ExitOnError)
+
+	MergeCmd := flag.NewFlagSet(CMD_MERGE, flag.ExitOnError)
+
+	ImportGitCmd := flag.NewFlagSet(CMD_IMPORT_GIT, flag.ExitOnError)
+
+	FastExportCmd := flag.NewFlagSet(CMD_FAST_EXPORT, flag.ExitOnError)
+
+	GCCmd := flag.NewFlagSet(CMD_GC, flag.ExitOnError)
+	gcPrune := GCCmd.String("prune", "", "Prune unreachable objects older than this (a duration, or \"now\"); two weeks by default")
+	gcRepack := GCCmd.Bool("repack", false, "Pack the reachable objects afterwards")
+
+	ConfigCmd := flag.NewFlagSet(CMD_CONFIG, flag.ExitOnError)
+	configGlobal := ConfigCmd.Bool("global", false, "Use ~/.ugitconfig rather than the repository config")
+
+	TagCmd := flag.NewFlagSet(CMD_TAG, flag.ExitOnError)
+	tagDelete := TagCmd.Bool("d", false, "Delete the named tags")
+	tagAnnotate := TagCmd.Bool("a", false, "Create an annotated tag object")
+	tagMessage := TagCmd.String("m", "", "The message of an annotated tag (implies -a)")
+
+	RevParseCmd := flag.NewFlagSet(CMD_REV_PARSE, flag.ExitOnError)
+
+	ReflogCmd := flag.NewFlagSet(CMD_REFLOG, flag.ExitOnError)
+
+	WorktreeAddCmd := flag.NewFlagSet(CMD_WORKTREE+" "+CMD_WORKTREE_ADD, flag.ExitOnError)
+	worktreeAddBranch := WorktreeAddCmd.String("b", "", "Create a branch of this name and check it out")
+	WorktreeListCmd := flag.NewFlagSet(CMD_WORKTREE+" "+CMD_WORKTREE_LIST, flag.ExitOnError)
+	WorktreeRemoveCmd := flag.NewFlagSet(CMD_WORKTREE+" "+CMD_WORKTREE_REMOVE, flag.ExitOnError)
+	worktreeRemoveForce := WorktreeRemoveCmd.Bool("f", false, "Remove the worktree even if it has changes or untracked files")
+
+	// -C <path> runs as if started in path; it can be repeated
+	for len(os.Args) > 2 && os.Args[1] == "-C" {
+		if err := os.Chdir(os.Args[2]); err != nil {
+			log.Fatalf("[ERROR] %s", err)
+		}
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+	}
 	if len(os.Args) < 2 {
 		fmt.Println("expected a subcommand")
 		os.Exit(1)
 	}
 
-	var err error
+	// init creates the repository here rather than looking for one, and
+	// config -global works outside of any repository
+	dataRepo, err := data.FindRepository(".", os.Args[1] != CMD_INIT)
+	if err != nil && os.Args[1] == CMD_CONFIG {
+		dataRepo, err = data.FindRepository(".", false)
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] %s", err)
+	}
+	repo = base.NewRepository(dataRepo)
 	switch os.Args[1] {
 	case CMD_INIT:
-		// initCmd.Parse(os.Args[2:])
-		err = initialize()
+		initCmd.Parse(os.Args[2:])
+		err = initialize(initCmd.Args(), *initObjectFormat, *initBare)
 	case CMD_HASH_OBJECT:
 		hashObjectCmd.Parse(os.Args[2:])
 		err = hashObject(*hashObjectFile)
@@ -156,6 +829,76 @@ func main() {
 	case CMD_LOG:
 		LogCmd.Parse(os.Args[2:])
 		err = printLog(*logOid)
+	case CMD_BRANCH:
+		BranchCmd.Parse(os.Args[2:])
+		err = branch(BranchCmd.Args(), *branchDelete)
+	case CMD_CHECKOUT:
+		CheckoutCmd.Parse(os.Args[2:])
+		err = checkout(CheckoutCmd.Args())
+	case CMD_ADD:
+		AddCmd.Parse(os.Args[2:])
+		err = add(AddCmd.Args())
+	case CMD_RM:
+		RmCmd.Parse(os.Args[2:])
+		err = rm(RmCmd.Args(), *rmCached, *rmForce)
+	case CMD_STATUS:
+		err = status()
+	case CMD_DIFF:
+		DiffCmd.Parse(os.Args[2:])
+		err = diff(DiffCmd.Args(), *diffCached)
+	case CMD_SHOW:
+		ShowCmd.Parse(os.Args[2:])
+		err = show(ShowCmd.Args())
+	case CMD_MERGE:
+		MergeCmd.Parse(os.Args[2:])
+		err = merge(MergeCmd.Args())
+	case CMD_CONFIG:
+		ConfigCmd.Parse(os.Args[2:])
+		err = config(ConfigCmd.Args(), *configGlobal)
+	case CMD_TAG:
+		TagCmd.Parse(os.Args[2:])
+		err = tag(TagCmd.Args(), *tagDelete, *tagAnnotate, *tagMessage)
+	case CMD_REV_PARSE:
+		RevParseCmd.Parse(os.Args[2:])
+		err = revParse(RevParseCmd.Args())
+	case CMD_MIGRATE:
+		err = migrate()
+	case CMD_IMPORT_GIT:
+		ImportGitCmd.Parse(os.Args[2:])
+		err = importGit(ImportGitCmd.Args())
+	case CMD_FAST_EXPORT:
+		FastExportCmd.Parse(os.Args[2:])
+		err = fastExport(FastExportCmd.Args())
+	case CMD_FAST_IMPORT:
+		err = fastImport()
+	case CMD_REPACK:
+		err = repack()
+	case CMD_GC:
+		GCCmd.Parse(os.Args[2:])
+		err = gc(*gcPrune, *gcRepack)
+	case CMD_FSCK:
+		err = fsck()
+	case CMD_REFLOG:
+		ReflogCmd.Parse(os.Args[2:])
+		err = reflog(ReflogCmd.Args())
+	case CMD_WORKTREE:
+		action := ""
+		if len(os.Args) > 2 {
+			action = os.Args[2]
+		}
+		switch action {
+		case CMD_WORKTREE_ADD:
+			WorktreeAddCmd.Parse(os.Args[3:])
+			err = worktreeAdd(WorktreeAddCmd.Args(), *worktreeAddBranch)
+		case CMD_WORKTREE_LIST:
+			WorktreeListCmd.Parse(os.Args[3:])
+			err = worktreeList()
+		case CMD_WORKTREE_REMOVE:
+			WorktreeRemoveCmd.Parse(os.Args[3:])
+			err = worktreeRemove(WorktreeRemoveCmd.Args(), *worktreeRemoveForce)
+		default:
+			err = errors.New("usage: worktree add|list|remove")
+		}
 	default:
 		err = errors.New(fmt.Sprintf("unknown subcommand %s", os.Args[1]))
 